@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// AccuracyBands are the edges, in kilometers, of the distance bands that
+// accuracy reports on, from a meter to half way round the Earth.
+var AccuracyBands = []float64{0.001, 0.01, 0.1, 1, 10, 100, 1000, 10000, 20004}
+
+// accuracyBand returns the index of the band containing distance, or -1.
+func accuracyBand(distance float64) int {
+	for i := 0; i < len(AccuracyBands)-1; i++ {
+		if distance >= AccuracyBands[i] && distance < AccuracyBands[i+1] {
+			return i
+		}
+	}
+	return -1
+}
+
+// formatKm formats a distance in kilometers with a readable unit.
+func formatKm(km float64) string {
+	if km < 1 {
+		return fmt.Sprintf("%gm", km*1000)
+	}
+	return fmt.Sprintf("%gkm", km)
+}
+
+// accuracyPair is a pair of points and the ellipsoidal distance between
+// them.
+type accuracyPair struct {
+	p, q      Point
+	reference float64
+}
+
+// newAccuracyPair measures the reference distance from p to q.
+func newAccuracyPair(p, q Point) accuracyPair {
+	return accuracyPair{p, q, WGS84.Karney(p, q).Distance}
+}
+
+// SyntheticPairs returns n pairs of points for each accuracy band, at
+// distances spread log-uniformly across the band from points spread
+// uniformly over the globe, in random directions.
+func SyntheticPairs(n int, rnd *rand.Rand) []accuracyPair {
+	var pairs []accuracyPair
+	for band := 0; band < len(AccuracyBands)-1; band++ {
+		lo, hi := AccuracyBands[band], AccuracyBands[band+1]
+		for i := 0; i < n; i++ {
+			p := Point{rnd.Float64()*360 - 180, deg(math.Asin(2*rnd.Float64() - 1))}
+			distance := lo * math.Pow(hi/lo, rnd.Float64())
+			q, _ := WGS84.Direct(p, rnd.Float64()*360-180, distance)
+			pairs = append(pairs, newAccuracyPair(p, q))
+		}
+	}
+	return pairs
+}
+
+// TablePairs returns n pairs of points taken from random rows of table.
+func TablePairs(n int, table string, rnd *rand.Rand, db *sql.DB) ([]accuracyPair, error) {
+	points, err := RandomAnchors(2*n, table, rnd, db)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]accuracyPair, n)
+	for i := range pairs {
+		pairs[i] = newAccuracyPair(points[2*i], points[2*i+1])
+	}
+	return pairs, nil
+}
+
+// AccuracyRowQuery evaluates distance expressions from a point to a single
+// row, built the way the loader stores it. It is formatted with the
+// expressions and the geog column, if the server has one. It is bound with
+// the expressions' arguments, then lon and lat three times over, and once
+// more for geog.
+var AccuracyRowQuery = `SELECT %[1]s FROM (SELECT CAST(? AS DECIMAL(10,7)) AS lon, CAST(? AS DECIMAL(10,7)) AS lat,
+	CAST(RADIANS(?) AS DECIMAL(10,7)) AS rlon_d, CAST(RADIANS(?) AS DECIMAL(10,7)) AS rlat_d,
+	POINT(?, ?) AS geom%[2]s) AS t;`
+
+// accuracyGeogColumn adds geog to AccuracyRowQuery.
+var accuracyGeogColumn = ", ST_SRID(POINT(?, ?), 4326) AS geog"
+
+// sqlDistances evaluates the distance expressions of strategies from pair.p
+// to pair.q, as stored in a row, in kilometers under the Earth model earth.
+func sqlDistances(strategies []*templateStrategy, pair accuracyPair, earth EarthModel, geog bool, db *sql.DB) ([]float64, error) {
+	exprs := make([]string, len(strategies))
+	var args []interface{}
+	for i, s := range strategies {
+		exprs[i] = s.distance
+		args = append(args, s.args(pair.p)...)
+	}
+	q := pair.q
+	args = append(args, q.Lon, q.Lat, q.Lon, q.Lat, q.Lon, q.Lat)
+	column := ""
+	if geog {
+		column = accuracyGeogColumn
+		args = append(args, q.Lon, q.Lat)
+	}
+
+	values := make([]sql.NullFloat64, len(strategies))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := db.QueryRow(fmt.Sprintf(AccuracyRowQuery, strings.Join(exprs, ", "), column), args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+	distances := make([]float64, len(values))
+	for i, v := range values {
+		distances[i] = math.NaN()
+		if v.Valid {
+			distances[i] = v.Float64 * strategies[i].scale(Kilometer, earth)
+		}
+	}
+	return distances, nil
+}
+
+// accuracyStrategies returns the registered strategies whose distance
+// expressions can be evaluated on the server, one per distinct expression.
+func accuracyStrategies(earth EarthModel, geog bool, db *sql.DB) []*templateStrategy {
+	var usable []*templateStrategy
+	seen := make(map[string]bool)
+	probe := newAccuracyPair(Point{11.39, 47.26}, Point{11.40, 47.27})
+	for _, s := range Strategies() {
+		t, ok := s.(*templateStrategy)
+		if !ok || seen[t.distance] {
+			continue
+		}
+		seen[t.distance] = true
+		if _, err := sqlDistances([]*templateStrategy{t}, probe, earth, geog, db); err != nil {
+			fmt.Printf("Skipping %s: %s\n", t.name, Yellow("%v", err))
+			continue
+		}
+		usable = append(usable, t)
+	}
+	return usable
+}
+
+// ErrorSummary holds the distribution of a method's errors in one band.
+type ErrorSummary struct {
+	Count     int
+	Failed    int     // results that were NaN or NULL
+	AbsMedian float64 // absolute error in meters
+	AbsP95    float64
+	AbsMax    float64
+	RelMedian float64 // error relative to the reference distance
+	RelMax    float64
+}
+
+// SummarizeErrors summarizes the errors of results against references, in
+// kilometers.
+func SummarizeErrors(results, references []float64) ErrorSummary {
+	var abs, rel []float64
+	s := ErrorSummary{Count: len(results)}
+	for i, r := range results {
+		if math.IsNaN(r) {
+			s.Failed++
+			continue
+		}
+		e := math.Abs(r - references[i])
+		abs = append(abs, e*1000)
+		rel = append(rel, e/references[i])
+	}
+	if len(abs) == 0 {
+		return s
+	}
+	sort.Float64s(abs)
+	sort.Float64s(rel)
+	s.AbsMedian = percentile(abs, 50)
+	s.AbsP95 = percentile(abs, 95)
+	s.AbsMax = abs[len(abs)-1]
+	s.RelMedian = percentile(rel, 50)
+	s.RelMax = rel[len(rel)-1]
+	return s
+}
+
+// Accuracy compares every distance method against the WGS 84 ellipsoid
+func (gc *GeoCommand) Accuracy(context *kingpin.ParseContext) error {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	earth, err := LookupEarthModel(gc.Earth)
+	if err != nil {
+		return err
+	}
+
+	// The Go formulas take lon, lat, tlon, tlat, and measure on the mean
+	// sphere; they are rescaled to the model's.
+	scale := earth.Radius.Kilometers() / Rk
+	methods := []string{"Distance", "Distance2"}
+	goMethods := []func(p, q Point) float64{
+		func(p, q Point) float64 { return Distance(p.Lon, p.Lat, q.Lon, q.Lat) * scale },
+		func(p, q Point) float64 { return Distance2(p.Lon, p.Lat, q.Lon, q.Lat) * scale },
+	}
+
+	var db *sql.DB
+	var sqlMethods []*templateStrategy
+	var geog bool
+	if !gc.Local || gc.PairSource == "table" {
+		db = gc.Connect()
+		defer db.Close()
+	}
+	if !gc.Local {
+		server, err := GetServerInfo(db)
+		if err != nil {
+			return err
+		}
+		geog = server.AtLeast(GeographicVersion)
+		sqlMethods = accuracyStrategies(earth, geog, db)
+		for _, s := range sqlMethods {
+			methods = append(methods, s.name)
+		}
+	}
+
+	var pairs []accuracyPair
+	if gc.PairSource == "table" {
+		if pairs, err = TablePairs(gc.Pairs*(len(AccuracyBands)-1), gc.Table, rnd, db); err != nil {
+			return err
+		}
+	} else {
+		pairs = SyntheticPairs(gc.Pairs, rnd)
+	}
+
+	// results[band][method] are the distances measured, and references[band]
+	// the reference distances of the same pairs.
+	bands := len(AccuracyBands) - 1
+	results := make([][][]float64, bands)
+	references := make([][]float64, bands)
+	for i := range results {
+		results[i] = make([][]float64, len(methods))
+	}
+	for _, pair := range pairs {
+		band := accuracyBand(pair.reference)
+		if band < 0 {
+			continue
+		}
+		distances := make([]float64, 0, len(methods))
+		for _, f := range goMethods {
+			distances = append(distances, f(pair.p, pair.q))
+		}
+		if len(sqlMethods) > 0 {
+			d, err := sqlDistances(sqlMethods, pair, earth, geog, db)
+			if err != nil {
+				return err
+			}
+			distances = append(distances, d...)
+		}
+		references[band] = append(references[band], pair.reference)
+		for m, d := range distances {
+			results[band][m] = append(results[band][m], d)
+		}
+	}
+
+	fmt.Printf("Errors against the WGS 84 ellipsoid, %d %s pairs, spherical formulas on the %s Earth\n\n", len(pairs), gc.PairSource, earth.Name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "band\tmethod\tpairs\tfailed\tmedian m\tp95 m\tmax m\tmedian rel\tmax rel\t")
+	for band := range results {
+		if len(references[band]) == 0 {
+			continue
+		}
+		name := formatKm(AccuracyBands[band]) + "-" + formatKm(AccuracyBands[band+1])
+		for m, method := range methods {
+			s := SummarizeErrors(results[band][m], references[band])
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.3g\t%.3g\t%.3g\t%.2e\t%.2e\t\n",
+				name, method, s.Count, s.Failed, s.AbsMedian, s.AbsP95, s.AbsMax, s.RelMedian, s.RelMax)
+		}
+	}
+	return w.Flush()
+}
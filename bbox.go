@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Box is a latitude/longitude rectangle in degrees. MinLon is never greater
+// than MaxLon; a rectangle crossing the antimeridian is represented as two
+// Boxes.
+type Box struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// Contains reports whether p lies within the box.
+func (b Box) Contains(p Point) bool {
+	return p.Lon >= b.MinLon && p.Lon <= b.MaxLon && p.Lat >= b.MinLat && p.Lat <= b.MaxLat
+}
+
+// BoundingBoxes returns the boxes that together contain every point within
+// radius kilometers of p. Near the poles the boxes span every longitude, and
+// across the antimeridian they are split in two.
+//
+// See http://janmatuschek.de/LatitudeLongitudeBoundingCoordinates
+func BoundingBoxes(p Point, radius float64) []Box {
+	// Angular radius of the circle on the sphere.
+	r := radius / Rk
+	if r >= math.Pi {
+		return []Box{{-180, -90, 180, 90}}
+	}
+
+	lat := rad(p.Lat)
+	lon := rad(p.Lon)
+	minLat := lat - r
+	maxLat := lat + r
+
+	if minLat <= -math.Pi/2 || maxLat >= math.Pi/2 {
+		// The circle contains a pole, so every longitude is in range.
+		return []Box{{-180, deg(math.Max(minLat, -math.Pi/2)), 180, deg(math.Min(maxLat, math.Pi/2))}}
+	}
+
+	dLon := math.Asin(math.Sin(r) / math.Cos(lat))
+	minLon := lon - dLon
+	maxLon := lon + dLon
+	switch {
+	case minLon < -math.Pi:
+		return []Box{
+			{deg(minLon + 2*math.Pi), deg(minLat), 180, deg(maxLat)},
+			{-180, deg(minLat), deg(maxLon), deg(maxLat)},
+		}
+	case maxLon > math.Pi:
+		return []Box{
+			{deg(minLon), deg(minLat), 180, deg(maxLat)},
+			{-180, deg(minLat), deg(maxLon - 2*math.Pi), deg(maxLat)},
+		}
+	}
+	return []Box{{deg(minLon), deg(minLat), deg(maxLon), deg(maxLat)}}
+}
+
+// BoxesWhere renders a WHERE condition matching the rows whose lon and lat
+// columns fall within any of boxes, and the arguments to bind to it.
+func BoxesWhere(boxes []Box) (string, []interface{}) {
+	terms := make([]string, len(boxes))
+	var args []interface{}
+	for i, b := range boxes {
+		terms[i] = "(lon BETWEEN ? AND ? AND lat BETWEEN ? AND ?)"
+		args = append(args, b.MinLon, b.MaxLon, b.MinLat, b.MaxLat)
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+// BoxesMBRWhere renders a WHERE condition matching the rows whose geom column
+// falls within any of boxes, using MBRContains so that the SPATIAL KEY can be
+// used, and the arguments to bind to it. The boxes are given the SRID of the
+// geom column, longitude first, as MySQL only compares geometries of the same
+// SRID.
+func BoxesMBRWhere(boxes []Box, srid int) (string, []interface{}) {
+	terms := make([]string, len(boxes))
+	var args []interface{}
+	for i, b := range boxes {
+		if srid == 0 {
+			terms[i] = "MBRContains(ST_GeomFromText(?), geom)"
+			args = append(args, b.WKT())
+			continue
+		}
+		terms[i] = "MBRContains(ST_GeomFromText(?, ?, 'axis-order=long-lat'), geom)"
+		args = append(args, b.WKT(), srid)
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+// WKT returns the box as a well-known text polygon.
+func (b Box) WKT() string {
+	return fmt.Sprintf("POLYGON((%[1]s %[2]s, %[3]s %[2]s, %[3]s %[4]s, %[1]s %[4]s, %[1]s %[2]s))",
+		formatCoord(b.MinLon), formatCoord(b.MinLat), formatCoord(b.MaxLon), formatCoord(b.MaxLat))
+}
+
+func init() {
+	RegisterStrategy(&templateStrategy{
+		name:        "bbox",
+		description: "lon/lat bounding box on the indexed columns, then the law of cosines",
+		requires:    []Requirement{{"column", "lon"}, {"column", "lat"}, {"index", "lon"}, {"index", "lat"}},
+		distance:    InlineDistance,
+		args:        latLonLat,
+		prefilter: func(p Point, radiusKm float64, _ int) (string, []interface{}) {
+			return BoxesWhere(BoundingBoxes(p, radiusKm))
+		},
+	})
+	RegisterStrategy(&templateStrategy{
+		name:         "mbr",
+		description:  "MBRContains on the SPATIAL KEY, then st_distance_sphere",
+		requires:     []Requirement{{"column", "geom"}, {"index", "geom"}},
+		distance:     SpatialFuncDistance,
+		sridDistance: SpatialFuncDistanceSRID,
+		args:         lonLat,
+		prefilter: func(p Point, radiusKm float64, srid int) (string, []interface{}) {
+			return BoxesMBRWhere(BoundingBoxes(p, radiusKm), srid)
+		},
+	})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func formatCoord(deg float64) string {
+	return strconv.FormatFloat(deg, 'f', -1, 64)
+}
+
+// ParsePoint parses a point given as "lon,lat" in degrees.
+func ParsePoint(s string) (Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Point{}, fmt.Errorf("point %q must be in the form lon,lat", s)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("point %q: bad longitude: %v", s, err)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("point %q: bad latitude: %v", s, err)
+	}
+	return Point{lon, lat}, nil
+}
+
+// TimeQuery runs a query rendered by s and returns how long the query took to
+// return, how long fetching its rows took, and the number of rows fetched. If
+// onRow is not nil it is called for every row fetched. The connection
+// interpolates args on the client, so binding them costs no extra round trip
+// to the server and is not part of the query time.
+func TimeQuery(s QueryStrategy, query string, args []interface{}, db *sql.DB, onRow func(m Match)) (queryTime, fetchTime time.Duration, count int, err error) {
+	start := time.Now()
+	rows, err := db.Query(query, args...)
+	queryTime = time.Since(start)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	start = time.Now()
+	for rows.Next() {
+		var m Match
+		if m, err = s.Scan(rows); err != nil {
+			return
+		}
+		if onRow != nil {
+			onRow(m)
+		}
+		count++
+	}
+	err = rows.Err()
+	fetchTime = time.Since(start)
+	return
+}
+
+// SelectStrategies returns the strategies named by names, or every registered
+// strategy if names is empty, that can run against table. Strategies whose
+// schema requirements are not met are an error when asked for by name,
+// unless skip is set, and are skipped with a warning otherwise. In a matrix,
+// strategies run on the variant tables without their index requirements.
+func (gc *GeoCommand) SelectStrategies(names []string, table string, skip bool, db *sql.DB) ([]QueryStrategy, error) {
+	candidates := Strategies()
+	if len(names) == 0 {
+		skip = true
+	} else {
+		candidates = nil
+		for _, name := range names {
+			s, err := LookupStrategy(name)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, s)
+		}
+	}
+	var selected []QueryStrategy
+	for _, s := range candidates {
+		if gc.Matrix && table != gc.Table {
+			s = unindexed{s}
+		}
+		if err := CheckRequirements(s, gc.Schema, table, db); err != nil {
+			if !skip {
+				return nil, err
+			}
+			fmt.Printf("Skipping %s\n", Yellow("%v", err))
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// RandomAnchors picks n anchor points from random rows of table.
+func RandomAnchors(n int, table string, rnd *rand.Rand, db *sql.DB) ([]Point, error) {
+	var anchors []Point
+	var maxID int
+	if err := ValidateTable(table); err != nil {
+		return nil, err
+	}
+	err := db.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM %s;", QuoteTable(table))).Scan(&maxID)
+	if err != nil {
+		return nil, err
+	}
+	if maxID == 0 {
+		return nil, fmt.Errorf("table %s is empty, no anchors to pick", table)
+	}
+	for len(anchors) < n {
+		var p Point
+		err := db.QueryRow(fmt.Sprintf("SELECT lon, lat FROM %s WHERE id >= ? ORDER BY id LIMIT 1;", QuoteTable(table)), rnd.Intn(maxID)+1).Scan(&p.Lon, &p.Lat)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, p)
+	}
+	return anchors, nil
+}
+
+// AnchorPoints returns the anchor points given with --anchor, or if there are
+// none, --anchors points picked from random rows of the table, or with
+// --density from the densest or sparsest hex cells of it.
+func (gc *GeoCommand) AnchorPoints(rnd *rand.Rand, db *sql.DB) ([]Point, error) {
+	var anchors []Point
+	for _, a := range gc.Anchors {
+		p, err := ParsePoint(a)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, p)
+	}
+	if len(anchors) == 0 && gc.AnchorDensity != "" && gc.AnchorDensity != "any" {
+		return DensityAnchors(gc.RandomAnchors, gc.AnchorDensity == "dense", gc.Resolution, gc.Table, db)
+	}
+	if len(anchors) == 0 {
+		return RandomAnchors(gc.RandomAnchors, gc.Table, rnd, db)
+	}
+	return anchors, nil
+}
+
+// benchTarget is a strategy to benchmark, the table to run it against, and
+// the SRID of the table's geom column.
+type benchTarget struct {
+	table    string
+	srid     int
+	strategy QueryStrategy
+}
+
+// Benchmark runs every query strategy against a set of anchor points. Each
+// round runs all strategies for all anchors, so that drift in the server's
+// state affects every strategy alike. Warmup rounds are run first and
+// discarded. With --matrix every strategy is run against the address table
+// and each of its variant tables. With --memory the same searches are also
+// answered from in-memory indexes, as a baseline for the database.
+func (gc *GeoCommand) Benchmark(context *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	tables := []MatrixTable{{gc.Table, 0}}
+	if gc.Matrix {
+		var err error
+		if tables, err = gc.MatrixTables(db); err != nil {
+			return err
+		}
+	}
+	var targets []benchTarget
+	for _, table := range tables {
+		queries, err := gc.SelectStrategies(gc.Queries, table.Name, gc.Matrix, db)
+		if err != nil {
+			return err
+		}
+		for _, q := range queries {
+			targets = append(targets, benchTarget{table.Name, table.SRID, q})
+		}
+	}
+	anchors, err := gc.AnchorPoints(rnd, db)
+	if err != nil {
+		return err
+	}
+
+	results, err := gc.NewResults("benchmark", db)
+	if err != nil {
+		return err
+	}
+	var indexes []SpatialIndex
+	if gc.Memory {
+		if indexes, err = gc.BuildIndexes(results, db); err != nil {
+			return err
+		}
+	}
+
+	// In a matrix, a strategy that fails against a variant is dropped from
+	// it rather than stopping the run, and reported as failed at the end.
+	failed := make(map[benchTarget]bool)
+	rounds := gc.Warmup + gc.Iterations
+	for round := 0; round < rounds; round++ {
+		warmup := round < gc.Warmup
+		if !gc.Quiet {
+			if warmup {
+				fmt.Printf("Warmup round %d/%d\n", round+1, gc.Warmup)
+			} else {
+				fmt.Printf("Iteration %v/%d\n", Green("%d", round-gc.Warmup+1), gc.Iterations)
+			}
+		}
+		for _, target := range targets {
+			if failed[target] {
+				continue
+			}
+			q := target.strategy
+			search := gc.Search()
+			search.SRID = target.srid
+			for _, anchor := range anchors {
+				query, args := q.SQL(target.table, anchor, search)
+				queryTime, fetchTime, count, err := TimeQuery(q, query, args, db, nil)
+				if err != nil && gc.Matrix {
+					fmt.Printf("Dropping %s on %s: %s\n", q.Name(), target.table, Yellow("%v", err))
+					failed[target] = true
+					results.Failures = append(results.Failures, Failure{q.Name(), target.table, err.Error()})
+					break
+				}
+				if err != nil {
+					fmt.Println(query)
+					return err
+				}
+				if warmup {
+					continue
+				}
+				m := Measurement{
+					QueryType: q.Name(),
+					Anchor:    anchor,
+					Iteration: round - gc.Warmup + 1,
+					QueryTime: queryTime,
+					FetchTime: fetchTime,
+					Rows:      count,
+				}
+				if gc.Matrix {
+					m.Variant = target.table
+				}
+				results.Add(m)
+			}
+		}
+		for i, index := range indexes {
+			for _, anchor := range anchors {
+				queryTime, count, err := TimeIndex(index, anchor, gc.Search())
+				if err != nil {
+					return err
+				}
+				if warmup {
+					continue
+				}
+				m := Measurement{
+					QueryType: MemoryIndexes[i].name,
+					Anchor:    anchor,
+					Iteration: round - gc.Warmup + 1,
+					QueryTime: queryTime,
+					Rows:      count,
+				}
+				if gc.Matrix {
+					m.Variant = "memory"
+				}
+				results.Add(m)
+			}
+		}
+	}
+
+	if gc.ResultsFile != "" {
+		if err := results.Write(gc.ResultsFile); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\n%d anchors, %d warmup rounds, %d iterations, radius %v %s\n\n", len(anchors), gc.Warmup, gc.Iterations, gc.Radius, gc.Unit)
+	if gc.Matrix {
+		if err := PrintMatrix(results); err != nil {
+			return err
+		}
+		if len(results.Failures) > 0 {
+			return fmt.Errorf("%d query types failed on a variant table", len(results.Failures))
+		}
+		return nil
+	}
+	return PrintSummaries(results)
+}
+
+// BuildIndexes builds the in-memory baselines over the points of the address
+// table, or of the CSV files given with --points, and records each build in
+// results.
+func (gc *GeoCommand) BuildIndexes(results *Results, db *sql.DB) ([]SpatialIndex, error) {
+	var points []IndexedPoint
+	var err error
+	if len(gc.PointFiles) > 0 {
+		points, err = FilePoints(gc.PointFiles)
+	} else {
+		points, err = TablePoints(gc.Table, db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []SpatialIndex
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "index	points	build	memory	")
+	for _, mi := range MemoryIndexes {
+		index, build := BuildIndex(mi, points)
+		indexes = append(indexes, index)
+		results.Builds = append(results.Builds, build)
+		fmt.Fprintf(w, "%s\t%d\t%v\t%.1f MiB\t\n", build.QueryType, build.Points, build.BuildTime, float64(build.Memory)/(1<<20))
+	}
+	return indexes, w.Flush()
+}
+
+func PrintSummaries(results *Results) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "query\tphase\tmin\tmedian\tp95\tp99\tmax\tavg rows\t")
+	for _, queryType := range results.QueryTypes() {
+		queryTimes, fetchTimes, rows := results.Timings(queryType)
+		avgRows := 0
+		if len(queryTimes) > 0 {
+			avgRows = rows / len(queryTimes)
+		}
+		for _, phase := range []struct {
+			name    string
+			samples []time.Duration
+		}{{"query", queryTimes}, {"fetch", fetchTimes}} {
+			s := Summarize(phase.samples)
+			fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%v\t%v\t%v\t%d\t\n", queryType, phase.name, s.Min, s.Median, s.P95, s.P99, s.Max, avgRows)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// SourceProgress records how much of a source has been committed.
+type SourceProgress struct {
+	Rows   int64 `json:"rows"`   // data rows read, including rejects, up to the last commit
+	Offset int64 `json:"offset"` // byte offset in the uncompressed CSV just after those rows
+	Done   bool  `json:"done"`   // every row of the source has been committed
+}
+
+// Checkpoint tracks the committed progress of every source of a load in a
+// JSON file, so that an interrupted load can resume where it stopped. It is
+// safe for concurrent use.
+type Checkpoint struct {
+	path    string
+	mu      sync.Mutex
+	Sources map[string]SourceProgress `json:"sources"`
+}
+
+// OpenCheckpoint reads the checkpoint file at path, or starts a new one if
+// it does not exist.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, Sources: make(map[string]SourceProgress)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Progress returns the committed progress of source.
+func (c *Checkpoint) Progress(source string) SourceProgress {
+	if c == nil {
+		return SourceProgress{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Sources[source]
+}
+
+// Save records the progress of source and writes the checkpoint file. The
+// file is replaced atomically, so a crash leaves either the old or the new
+// checkpoint. Saving to a nil Checkpoint does nothing.
+func (c *Checkpoint) Save(source string, p SourceProgress) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sources[source] = p
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// RejectWriter collects the rows a load skips, with the reason each was
+// rejected. If it was created with a path the rows are also written to that
+// CSV file. It is safe for concurrent use.
+type RejectWriter struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *csv.Writer
+	count   int64
+	reasons map[string]int64
+	written map[rejectKey]bool // rows already in the file when a load resumed
+}
+
+// rejectKey identifies a rejected row by its source and row number.
+type rejectKey struct {
+	source string
+	row    int64
+}
+
+// NewRejectWriter creates a RejectWriter writing to the CSV file at path, or
+// only counting rejects if path is empty. A new load starts the file afresh.
+// A load resuming from a checkpoint appends to it, and rereads the rows
+// after the checkpoint, so rows already in the file are not written again.
+func NewRejectWriter(path string, resume bool) (*RejectWriter, error) {
+	r := &RejectWriter{reasons: make(map[string]int64), written: make(map[rejectKey]bool)}
+	if path == "" {
+		return r, nil
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if err := r.readWritten(path); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	r.w = csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		r.w.Write([]string{"source", "row", "reason", "fields..."})
+	}
+	return r, nil
+}
+
+// readWritten records the rows already in the reject file at path, if it
+// exists. A record cut short by a killed load ends the rows read.
+func (r *RejectWriter) readWritten(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			return nil
+		}
+		if len(record) < 2 {
+			continue
+		}
+		if row, err := strconv.ParseInt(record[1], 10, 64); err == nil {
+			r.written[rejectKey{record[0], row}] = true
+		}
+	}
+}
+
+// Reject records that data row number row of source, whose fields are given,
+// was skipped for reason. kind is a short category used in the summary.
+func (r *RejectWriter) Reject(source string, row int64, kind, reason string, fields []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	r.reasons[kind]++
+	if r.f == nil || r.written[rejectKey{source, row}] {
+		return nil
+	}
+	record := append([]string{source, strconv.FormatInt(row, 10), reason}, fields...)
+	if err := r.w.Write(record); err != nil {
+		return err
+	}
+	// Flush every reject so that the file is complete if the load is killed.
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// Count returns the number of rows rejected, and the count by kind.
+func (r *RejectWriter) Count() (int64, map[string]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reasons := make(map[string]int64, len(r.reasons))
+	for k, v := range r.reasons {
+		reasons[k] = v
+	}
+	return r.count, reasons
+}
+
+// Close flushes and closes the reject file. Closing it again does nothing.
+func (r *RejectWriter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	f := r.f
+	r.f = nil
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
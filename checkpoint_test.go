@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// rejectRows returns the source and row of every record in the reject file
+// at path, after the header.
+func rejectRows(t *testing.T, path string) [][2]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][2]string
+	for _, record := range records[1:] {
+		rows = append(rows, [2]string{record[0], record[1]})
+	}
+	return rows
+}
+
+func TestRejectWriterResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+	write := func(resume bool, rows ...int64) {
+		r, err := NewRejectWriter(path, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := r.Reject("a.csv", row, "csv", "bad row", []string{"x"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count, _ := r.Count(); count != int64(len(rows)) {
+			t.Errorf("counted %d rejects, want %d", count, len(rows))
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A load killed after rejecting rows 4 and 9, but committing only up
+	// to row 5, rereads row 9 when it resumes.
+	write(false, 4, 9)
+	write(true, 9, 12)
+	want := [][2]string{{"a.csv", "4"}, {"a.csv", "9"}, {"a.csv", "12"}}
+	if rows := rejectRows(t, path); !reflect.DeepEqual(rows, want) {
+		t.Errorf("resumed reject file has %v, want %v", rows, want)
+	}
+
+	// A new load starts the file afresh.
+	write(false, 7)
+	want = [][2]string{{"a.csv", "7"}}
+	if rows := rejectRows(t, path); !reflect.DeepEqual(rows, want) {
+		t.Errorf("new reject file has %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// AddressField is an Address field that a CSV column can be mapped to.
+type AddressField struct {
+	Name     string // field name used in mappings
+	Header   string // OpenAddresses header the field maps from by default
+	Required bool
+}
+
+// AddressFields lists the fields of an Address in loading order.
+var AddressFields = []AddressField{
+	{"lon", "LON", true},
+	{"lat", "LAT", true},
+	{"number", "NUMBER", false},
+	{"street", "STREET", false},
+	{"unit", "UNIT", false},
+	{"city", "CITY", false},
+	{"district", "DISTRICT", false},
+	{"region", "REGION", false},
+	{"postcode", "POSTCODE", false},
+}
+
+// ColumnMapping maps the columns of a CSV file to Address fields by header
+// name, so that files with extra or reordered columns load correctly.
+type ColumnMapping struct {
+	index   map[string]int // field name to column index, -1 if not present
+	columns int            // number of columns in the header
+}
+
+// NewColumnMapping maps the columns named in header to Address fields.
+// Headers are matched without regard to case. overrides maps field names to
+// the header to use instead of the default. A missing required column, or a
+// missing column named in overrides, is an error.
+func NewColumnMapping(header []string, overrides map[string]string) (*ColumnMapping, error) {
+	known := make(map[string]bool)
+	for _, f := range AddressFields {
+		known[f.Name] = true
+	}
+	for field := range overrides {
+		if !known[field] {
+			return nil, fmt.Errorf("unknown address field %q in column mapping", field)
+		}
+	}
+
+	columns := make(map[string]int)
+	for i, h := range header {
+		// The first header of a file may carry a UTF-8 byte order mark.
+		h = strings.TrimPrefix(strings.TrimSpace(h), "\ufeff")
+		columns[strings.ToUpper(h)] = i
+	}
+
+	m := &ColumnMapping{index: make(map[string]int), columns: len(header)}
+	var missing []string
+	for _, f := range AddressFields {
+		name, overridden := overrides[f.Name]
+		if !overridden {
+			name = f.Header
+		}
+		i, ok := columns[strings.ToUpper(name)]
+		if !ok {
+			if f.Required || overridden {
+				missing = append(missing, fmt.Sprintf("%s (for %s)", name, f.Name))
+			}
+			i = -1
+		}
+		m.index[f.Name] = i
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing columns %s; the header has %s", strings.Join(missing, ", "), strings.Join(header, ", "))
+	}
+	return m, nil
+}
+
+// ReadColumnMapping reads column mapping overrides from a file of
+// field=HEADER lines. Blank lines and lines starting with # are ignored.
+func ReadColumnMapping(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	overrides := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected field=HEADER", path, n)
+		}
+		overrides[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return overrides, scanner.Err()
+}
+
+// field returns the value of the named field in row, or "" if the field is
+// not mapped.
+func (m *ColumnMapping) field(row []string, name string) string {
+	i := m.index[name]
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Address converts a CSV row to an Address. Rows that cannot be converted
+// return a *RowError.
+func (m *ColumnMapping) Address(row []string) (Address, error) {
+	if len(row) != m.columns {
+		return Address{}, rowErrorf("columns", "expected %d columns, got %d", m.columns, len(row))
+	}
+	for i, field := range row {
+		if !utf8.ValidString(field) {
+			return Address{}, rowErrorf("encoding", "column %d is not valid UTF-8", i+1)
+		}
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(m.field(row, "lon")), 64)
+	if err != nil {
+		return Address{}, rowErrorf("coordinates", "bad longitude %q", m.field(row, "lon"))
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(m.field(row, "lat")), 64)
+	if err != nil {
+		return Address{}, rowErrorf("coordinates", "bad latitude %q", m.field(row, "lat"))
+	}
+	return Address{
+		Lon:      lon,
+		Lat:      lat,
+		Number:   m.field(row, "number"),
+		Street:   m.field(row, "street"),
+		Unit:     m.field(row, "unit"),
+		City:     m.field(row, "city"),
+		District: m.field(row, "district"),
+		Region:   m.field(row, "region"),
+		Postcode: m.field(row, "postcode"),
+	}, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Comparison is the change in one query type's timings between a baseline
+// run and a candidate run.
+type Comparison struct {
+	QueryType      string
+	BaselineMedian time.Duration
+	Median         time.Duration
+	Delta          float64 // relative change in median, (candidate - baseline) / baseline; NaN if the baseline median is 0
+	CILow          float64 // bootstrap confidence interval of Delta
+	CIHigh         float64
+	P              float64 // Mann-Whitney U p-value
+	Significant    bool
+	Regression     bool
+}
+
+// phaseSamples returns the samples of the given phase (query, fetch or total)
+// for queryType.
+func phaseSamples(r *Results, queryType, phase string) []float64 {
+	queryTimes, fetchTimes, _ := r.Timings(queryType)
+	switch phase {
+	case "fetch":
+		return durationsToFloats(fetchTimes)
+	case "total":
+		total := make([]time.Duration, len(queryTimes))
+		for i := range queryTimes {
+			total[i] = queryTimes[i] + fetchTimes[i]
+		}
+		return durationsToFloats(total)
+	}
+	return durationsToFloats(queryTimes)
+}
+
+// CompareResults compares every query type measured in both baseline and
+// candidate. A change is significant when the chosen test rejects the null
+// hypothesis at alpha, and is a regression when it is significant and the
+// median slowed down by more than threshold (a fraction, 0.05 for 5%).
+func CompareResults(baseline, candidate *Results, phase, method string, threshold, alpha float64, rnd *rand.Rand) []Comparison {
+	var comparisons []Comparison
+	for _, queryType := range baseline.QueryTypes() {
+		a := phaseSamples(baseline, queryType, phase)
+		b := phaseSamples(candidate, queryType, phase)
+		if len(b) == 0 {
+			continue
+		}
+		c := Comparison{
+			QueryType:      queryType,
+			BaselineMedian: time.Duration(median(a)),
+			Median:         time.Duration(median(b)),
+		}
+		if m := median(a); m != 0 {
+			c.Delta = (median(b) - m) / m
+			c.CILow, c.CIHigh = BootstrapMedianDelta(a, b, 2000, alpha, rnd)
+		} else {
+			// A change from nothing has no relative size.
+			c.Delta, c.CILow, c.CIHigh = math.NaN(), math.NaN(), math.NaN()
+		}
+		_, c.P = MannWhitneyU(a, b)
+
+		if method == "bootstrap" {
+			c.Significant = c.CILow > 0 || c.CIHigh < 0
+		} else {
+			c.Significant = c.P < alpha
+		}
+		c.Regression = c.Significant && c.Delta > threshold
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// CompareQueryTypes returns the query types measured in baseline but not in
+// candidate, and those measured in candidate but not in baseline.
+func CompareQueryTypes(baseline, candidate *Results) (missing, extra []string) {
+	measured := func(r *Results) map[string]bool {
+		types := make(map[string]bool)
+		for _, queryType := range r.QueryTypes() {
+			types[queryType] = true
+		}
+		return types
+	}
+	inBaseline, inCandidate := measured(baseline), measured(candidate)
+	for _, queryType := range baseline.QueryTypes() {
+		if !inCandidate[queryType] {
+			missing = append(missing, queryType)
+		}
+	}
+	for _, queryType := range candidate.QueryTypes() {
+		if !inBaseline[queryType] {
+			extra = append(extra, queryType)
+		}
+	}
+	return missing, extra
+}
+
+// MetadataMismatches returns the kind of search, its parameters, and the
+// table that differ between two runs, such as "radius 1 != 5". Results
+// recorded before runs carried metadata match any run, and those recorded
+// before the limit and order were, including older CSV files, match any
+// limit and order.
+func MetadataMismatches(a, b RunMetadata) []string {
+	if a.Command == "" || b.Command == "" {
+		return nil
+	}
+	// Runs from before the Earth model was recorded measured on the mean
+	// sphere.
+	earth := func(m RunMetadata) string {
+		if m.Earth == "" {
+			return "mean"
+		}
+		return m.Earth
+	}
+	// Select and benchmark run the same radius search; nearest searches
+	// for k rows out to a radius.
+	search := func(m RunMetadata) string {
+		if m.Command == "nearest" {
+			return "nearest"
+		}
+		return "radius"
+	}
+	fields := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"search", search(a), search(b)},
+		{"radius", a.Radius, b.Radius},
+		{"unit", a.Unit, b.Unit},
+		{"earth", earth(a), earth(b)},
+		{"limit", a.Limit, b.Limit},
+		{"order", a.Order, b.Order},
+		{"table", a.Table, b.Table},
+	}
+	// Every search has an order, so an empty one was never recorded.
+	unrecorded := a.Order == "" || b.Order == ""
+	var mismatches []string
+	for _, f := range fields {
+		if unrecorded && (f.name == "limit" || f.name == "order") {
+			continue
+		}
+		if f.a != f.b {
+			mismatches = append(mismatches, fmt.Sprintf("%s %v != %v", f.name, f.a, f.b))
+		}
+	}
+	return mismatches
+}
+
+// Compare reports the per query type changes of every results file against
+// the first one, and fails if any of them regressed or went unmeasured. Runs
+// of different searches are refused unless --force is given.
+func (gc *GeoCommand) Compare(context *kingpin.ParseContext) error {
+	if len(gc.CompareFiles) < 2 {
+		return fmt.Errorf("compare needs a baseline and at least one other results file")
+	}
+	baseline, err := ReadResults(gc.CompareFiles[0])
+	if err != nil {
+		return err
+	}
+	// A fixed seed keeps the confidence intervals reproducible between runs.
+	rnd := rand.New(rand.NewSource(1))
+
+	regressions, missing := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, path := range gc.CompareFiles[1:] {
+		candidate, err := ReadResults(path)
+		if err != nil {
+			return err
+		}
+		if mismatches := MetadataMismatches(baseline.Metadata, candidate.Metadata); len(mismatches) > 0 {
+			if !gc.Force {
+				return fmt.Errorf("%s and %s are runs of different searches: %s; use --force to compare them anyway",
+					gc.CompareFiles[0], path, strings.Join(mismatches, ", "))
+			}
+			fmt.Printf("%s and %s are runs of different searches: %s\n", gc.CompareFiles[0], path, Yellow("%s", strings.Join(mismatches, ", ")))
+		}
+		fmt.Fprintf(w, "%s vs %s (%s time)\t\t\t\t\t\t\t\n", gc.CompareFiles[0], path, gc.Phase)
+		fmt.Fprintln(w, "query\tbaseline\tmedian\tdelta\tci\tp\tverdict\t")
+		for _, c := range CompareResults(baseline, candidate, gc.Phase, gc.Method, gc.Threshold/100, gc.Alpha, rnd) {
+			verdict := "~"
+			if c.Regression {
+				verdict = Red("REGRESSION")
+				regressions++
+			} else if c.Significant && c.Delta < 0 {
+				verdict = Green("faster")
+			} else if c.Significant {
+				verdict = Yellow("slower")
+			}
+			delta, ci := "n/a", "n/a"
+			if !math.IsNaN(c.Delta) {
+				delta = fmt.Sprintf("%+.1f%%", c.Delta*100)
+				ci = fmt.Sprintf("[%+.1f%%, %+.1f%%]", c.CILow*100, c.CIHigh*100)
+			}
+			fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%s\t%.4f\t%s\t\n",
+				c.QueryType, c.BaselineMedian, c.Median, delta, ci, c.P, verdict)
+		}
+		absent, extra := CompareQueryTypes(baseline, candidate)
+		for _, queryType := range absent {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%s\t\n", queryType, Red("MISSING"))
+			missing++
+		}
+		for _, queryType := range extra {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%s\t\n", queryType, Cyan("new"))
+		}
+		fmt.Fprintln(w, "\t\t\t\t\t\t\t")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if regressions > 0 || missing > 0 {
+		return fmt.Errorf("%d regressions over %.1f%%, %d query types missing", regressions, gc.Threshold, missing)
+	}
+	return nil
+}
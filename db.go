@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"regexp"
 	"time"
 )
 
@@ -18,110 +19,144 @@ var UpdateGeomQuery = `
 UPDATE address SET geom = GeomFromText('POINT(11.40045540 47.23693990)') WHERE id='48e42824-f529-11e6-b9aa-6c4008befb36';
 `
 
-// CreateTableQuery
-var CreateTableQuery = `
-GRANT ALL ON *.* TO 'root'@'%' IDENTIFIED BY 'password';
-
-CREATE SCHEMA geo_data;
-USE geo_data;
-CREATE TABLE feed (
-  id int(12) NOT NULL AUTO_INCREMENT,
-  user_id int(12) NOT NULL,
-  slug varchar(50) CHARACTER SET latin1 NOT NULL,
-  category varchar(20) CHARACTER SET latin1 NOT NULL,
-  content text CHARACTER SET latin1 NOT NULL,
-  image1 varchar(40) CHARACTER SET latin1 NOT NULL,
-  image2 varchar(40) CHARACTER SET latin1 NOT NULL,
-  image3 varchar(40) CHARACTER SET latin1 NOT NULL,
-  image4 varchar(40) CHARACTER SET latin1 NOT NULL,
-  image5 varchar(40) CHARACTER SET latin1 NOT NULL,
-  reactions_happy int(7) NOT NULL,
-  reactions_love int(7) NOT NULL,
-  reactions_funny int(7) NOT NULL,
-  reactions_shocked int(7) NOT NULL,
-  reactions_sad int(7) NOT NULL,
-  reactions_angry int(7) NOT NULL,
-  lat decimal(10,8) NOT NULL,
-  lng decimal(11,8) NOT NULL,
-  reviewed tinyint(1) NOT NULL,
-  date_created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  PRIMARY KEY (id),
-  KEY lat (lat),
-  KEY lng (lng),
-  KEY category (category),
-  KEY user_id (user_id)
-) ENGINE=InnoDB  DEFAULT CHARSET=utf8 AUTO_INCREMENT=1638 ;
-
-CREATE TABLE comments (
-  id int(12) NOT NULL AUTO_INCREMENT,
-  parent_id int(12) NOT NULL,
-  user_id int(12) NOT NULL,
-  content text CHARACTER SET latin1 NOT NULL,
-  reviewed tinyint(1) NOT NULL,
-  date_created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  PRIMARY KEY (id),
-  KEY parent_id (parent_id)
-) ENGINE=InnoDB  DEFAULT CHARSET=utf8 AUTO_INCREMENT=3408 ;
-
-CREATE TABLE addr_inno (
-  id INT NOT NULL AUTO_INCREMENT,
-  lon decimal(10,7) NOT NULL,
-  lat decimal(10,7) NOT NULL,
-  geom point NOT NULL,
-  rlon_d decimal(10, 7) NOT NULL COMMENT 'lon as decimal radians',
-  rlat_d decimal(10, 7) NOT NULL COMMENT 'lat as decimal radians',
-  rlon_dd double(10, 7) NOT NULL COMMENT 'lon as double radians',
-  rlat_dd double(10, 7) NOT NULL COMMENT 'lat as double radians',
-  number varchar(32) DEFAULT NULL,
-  street varchar(64) DEFAULT NULL,
-  unit varchar(8) DEFAULT NULL,
-  city varchar(64) DEFAULT NULL,
-  district varchar(64) DEFAULT NULL,
-  region varchar(64) DEFAULT NULL,
-  postcode varchar(16) DEFAULT NULL,
-  PRIMARY KEY (id),
-  KEY lon (lon),
-  KEY lat (lat),
-  SPATIAL KEY geom (geom)
-) ENGINE=InnoDB DEFAULT CHARSET='utf8mb4';
-
-GRANT ALL ON geo_data.* TO 'geo_user'@'%' IDENTIFIED BY 'geo_password';
-
-CREATE DEFINER = 'geo_user'@'%' FUNCTION distance_loc (lon DECIMAL(12,8), lat DECIMAL(12,8), tlon DECIMAL(12,8), tlat DECIMAL(12,8)) 
-	RETURNS DECIMAL(12,8)
-	RETURN  3959 * ACOS( SIN( lat ) * SIN( tlat) + COS( tlat ) * COS( lat ) * COS( lon - tlon ) ) ;
-`
+// RadiusQuery selects the id and distance of the rows of a table. It is
+// rendered with the table, the distance expression, and the WHERE, HAVING,
+// ORDER BY and LIMIT clauses of the search, each of which may be empty. Any
+// values in them are bound as parameters.
+var RadiusQuery = "SELECT id, %[2]s AS distance FROM %[1]s%[3]s%[4]s%[5]s%[6]s;"
+
+// InlineDistance is the law of cosines over the degree columns. Like the
+// other spherical distance expressions, it returns the central angle in
+// radians, which the query scales by the radius of the Earth model. It is
+// bound with the lat, lon and lat of the point searched for. LEAST guards
+// ACOS against rounding past 1, which would otherwise drop the point itself.
+var InlineDistance = `ACOS(LEAST(1, COS(RADIANS(?))
+			* COS(RADIANS(lat))
+			* COS(RADIANS(lon) - RADIANS(?))
+			+ SIN(RADIANS(?)) * SIN(RADIANS(lat)) ) )`
+
+// InlineRadiansDistance is the law of cosines over the radian columns. It is
+// bound with the lat, lon and lat of the point in radians.
+var InlineRadiansDistance = `ACOS(LEAST(1, COS(?)
+			* COS(rlat_d)
+			* COS(rlon_d - ?)
+			+ SIN(?) * SIN(rlat_d) ) )`
+
+// StoredFuncDistance uses the stored function. Like the radian columns it is
+// passed, the function works in radians, and on a sphere of radius 1 it
+// returns the central angle. It is bound with the lon and lat of the point in
+// radians.
+var StoredFuncDistance = "distance_loc(rlon_d, rlat_d, ?, ?, 1)"
+
+// SpatialFuncDistance uses the MySQL spatial function, on a sphere of radius
+// 1 so that it returns the central angle. It is bound with the lon and lat of
+// the point.
+var SpatialFuncDistance = "st_distance_sphere(geom, POINT(?, ?), 1)"
+
+// SpatialFuncDistanceSRID is SpatialFuncDistance for a geom column with an
+// SRID, which MySQL 8 only compares with a point of the same SRID. It is
+// bound with the SRID after the point.
+var SpatialFuncDistanceSRID = "st_distance_sphere(geom, ST_SRID(POINT(?, ?), ?), 1)"
+
+// EllipsoidDistance uses ST_Distance on the SRID 4326 geog column, which
+// MySQL 8 measures on the WGS 84 ellipsoid, in meters. It is bound with the
+// lon and lat of the point. MySQL stores geographic points longitude first,
+// so ST_SRID can relabel POINT(lon, lat) without swapping its axes.
+var EllipsoidDistance = "ST_Distance(geog, ST_SRID(POINT(?, ?), 4326))"
+
+// InsertQuery inserts a batch of addresses. It is formatted with the table
+// name, one InsertValues row per address, and the optional columns the
+// table has, each of GeogColumn, GeohashColumn and S2CellColumn.
+var InsertQuery = `INSERT INTO %[1]s (lon, lat, rlon_d, rlat_d, rlon_dd, rlat_dd, geom, number, street, unit, city, district, region, postcode%[3]s) VALUES %[2]s;`
+
+// InsertValues is the row of InsertQuery for one address. It is formatted
+// with the values of the optional columns, each of GeogValue, GeohashValue
+// and S2CellValue. It is bound with lon and lat four times over, then the
+// number, street, unit, city, district, region and postcode, then the
+// arguments of the optional values.
+var InsertValues = `(?, ?, RADIANS(?), RADIANS(?), RADIANS(?), RADIANS(?), POINT(?, ?), ?, ?, ?, ?, ?, ?, ?%[1]s)`
+
+// GeogColumn names the geog column in InsertQuery, and GeogValue sets it in
+// InsertValues. GeogValue is bound with lon and lat.
+var (
+	GeogColumn = ", geog"
+	GeogValue  = ", ST_SRID(POINT(?, ?), 4326)"
+)
 
-// InlineQuery
-var InlineQuery = `
-SELECT id, (3956 * ACOS(COS(RADIANS(%[3]s)) 
-			* COS(RADIANS(lat)) 
-			* COS(RADIANS(lon) - RADIANS(%[2]s)) 
-			+ SIN(RADIANS(%[3]s)) * SIN(RADIANS(lat)) ) ) 
-			AS distance FROM %[1]s HAVING distance < $[4]s;
-`
+// GeohashColumn names the geohash column in InsertQuery, and GeohashValue
+// sets it in InsertValues. GeohashValue is bound with the geohash.
+var (
+	GeohashColumn = ", geohash"
+	GeohashValue  = ", ?"
+)
 
-// InlineRadiansQuery
-var InlineRadiansQuery = `
-SELECT id, (3956 * ACOS( COS(%[3]f) 
-			* COS(rlat_d) 
-			* COS(rlon_d - %[2]f) 
-			+ SIN(%[3]f) * SIN(rlat_d) ) ) 
-			AS distance FROM %[1]s HAVING distance < %[4]d;
-`
+// S2CellColumn names the s2cell column in InsertQuery, and S2CellValue sets
+// it in InsertValues. S2CellValue is bound with the leaf cell ID.
+var (
+	S2CellColumn = ", s2cell"
+	S2CellValue  = ", ?"
+)
+
+// LoadInfileQuery loads a batch of addresses from a tab separated reader
+// registered with the mysql driver. It is formatted with the reader name,
+// the table name, the optional input fields, each of GeohashInput and
+// S2CellInput, and GeogAssignment if the table has a geog column.
+var LoadInfileQuery = `LOAD DATA LOCAL INFILE 'Reader::%[1]s' INTO TABLE %[2]s CHARACTER SET utf8mb4
+	FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n'
+	(@lon, @lat, number, street, unit, city, district, region, postcode%[3]s)
+	SET lon = @lon, lat = @lat,
+		rlon_d = RADIANS(@lon), rlat_d = RADIANS(@lat),
+		rlon_dd = RADIANS(@lon), rlat_dd = RADIANS(@lat),
+		geom = POINT(@lon, @lat)%[4]s;`
+
+// GeohashInput and S2CellInput read the geohash and s2cell fields in
+// LoadInfileQuery.
+var (
+	GeohashInput = ", geohash"
+	S2CellInput  = ", s2cell"
+)
+
+// GeogAssignment sets the geog column in LoadInfileQuery.
+var GeogAssignment = `,
+		geog = ST_SRID(POINT(@lon, @lat), 4326)`
+
+// InsertFeedQuery inserts a row into the feed table.
+var InsertFeedQuery = `INSERT INTO feed (user_id, slug, category, content, image1, image2, image3, image4, image5, reactions_happy, reactions_love, reactions_funny, reactions_shocked, reactions_sad, reactions_angry, lat, lng, reviewed, date_created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+// InsertCommentQuery inserts a row into the comments table.
+var InsertCommentQuery = `INSERT INTO comments (parent_id, user_id, content, reviewed, date_created) VALUES (?, ?, ?, ?, ?);`
 
-//  StoredFuncQuery uses the stored function
-var StoredFuncQuery = "SELECT id, distance_loc(lon, lat, %[2]s, %[3]s) AS distance FROM %[1]s;"
+// validTable matches the table names that may be formatted into queries.
+// Table names cannot be bound as parameters, so every name that reaches a
+// query must match it, and is quoted with QuoteTable.
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// ValidateTable returns an error if table is not a valid table name.
+func ValidateTable(table string) error {
+	if !validTable.MatchString(table) {
+		return fmt.Errorf("invalid table name %q: it must be a letter or underscore, then up to 63 letters, digits or underscores", table)
+	}
+	return nil
+}
 
-// SpatialFuncQuery use MySQL spatial function
-var SpatialFuncQuery = "SELECT id, (st_distance_sphere(geom, POINT(%[2]s, %[3]s))/1000) as distance FROM %[1]s;"
+// QuoteTable quotes a table name checked by ValidateTable for a query.
+func QuoteTable(table string) string {
+	return "`" + table + "`"
+}
 
-// InsertQuery must be formatted locally to set the table name, then rendered with Prepare
-var InsertQuery = `INSERT INTO %[1]s (lon, lat, rlon_d, rlat_d, rlon_dd, rlat_dd, geom, number, street, unit, city, district, region, postcode) VALUES ( %[2]s, %[3]s, RADIANS(%[2]s), RADIANS(%[3]s), RADIANS(%[2]s), RADIANS(%[3]s),  POINT(%[2]s, %[3]s), "%[4]s", "%[5]s", "%[6]s", "%[7]s", "%[8]s", "%[9]s", "%[10]s");`
+// dataSourceName returns the DSN for schema on the MySQL server. An empty
+// schema connects without selecting one.
+func (gc *GeoCommand) dataSourceName(schema string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?interpolateParams=true", gc.User, gc.Password, gc.Host, gc.Port, schema)
+}
 
 // Connect to the MySQL database
 func (gc *GeoCommand) Connect() *sql.DB {
-	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?interpolateParams=true", gc.User, gc.Password, gc.Host, gc.Port, gc.Schema)
+	connectStr := gc.dataSourceName(gc.Schema)
+
+	if err := ValidateTable(gc.Table); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create an sql.DB and check for errors
 	db, err := sql.Open("mysql", connectStr)
@@ -136,7 +171,10 @@ func (gc *GeoCommand) Connect() *sql.DB {
 func GetRowCount(table string, db *sql.DB) int {
 	// Since it's auto_incremented, the last id used  = row count
 	var lastID int
-	err := db.QueryRow(fmt.Sprintf("select count(id) from %s;", table)).Scan(&lastID)
+	if err := ValidateTable(table); err != nil {
+		panic(err)
+	}
+	err := db.QueryRow(fmt.Sprintf("select count(id) from %s;", QuoteTable(table))).Scan(&lastID)
 	if err != nil {
 		panic(err)
 	}
@@ -145,8 +183,8 @@ func GetRowCount(table string, db *sql.DB) int {
 
 // Point struct
 type Point struct {
-	Lon float64
-	Lat float64
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
 }
 
 // ClusterMember hold the ID and distance of a row in proximity to another row
@@ -172,24 +210,21 @@ type Account struct {
 	Postcode string
 }
 
-// GetClusteredRows returns all the rows that are witnin 'radius' of the
-// provided longitude and latitude (provided as radians)
-func GetClusteredRows(rlon, rlat float64, radius int, db *sql.DB) []ClusterMember {
-	var cluster []ClusterMember
-	query := fmt.Sprintf(InlineRadiansQuery, "addr_inno", rlon, rlat, radius)
-	rows, err := db.Query(query)
+// GetClusteredRows returns the limit rows nearest to the provided longitude
+// and latitude (provided as radians) that are within 'radius' miles of it,
+// nearest first
+func GetClusteredRows(rlon, rlat float64, radius, limit int, db *sql.DB) []ClusterMember {
+	strategy, err := LookupStrategy("bbox")
 	if err != nil {
-		fmt.Println(query)
 		panic(err)
 	}
-
-	for rows.Next() {
-		var rID int
-		var rDistance float64
-		if err := rows.Scan(&rID, &rDistance); err != nil {
-			log.Fatal(err.Error())
-		}
-		cluster = append(cluster, ClusterMember{rID, rDistance})
+	result, err := FindNearest(strategy, "addr_inno", Point{deg(rlon), deg(rlat)}, NearestSearch{K: limit, Unit: "mi", MaxRadius: float64(radius)}, db)
+	if err != nil {
+		panic(err)
+	}
+	cluster, err := clusterMembers(result.Matches)
+	if err != nil {
+		log.Fatal(err.Error())
 	}
 	return cluster
 }
@@ -204,7 +239,7 @@ func GetLonLat(ID int, radians bool, db *sql.DB) (float64, float64) {
 	} else {
 		pt = "lon, lat"
 	}
-	err := db.QueryRow(fmt.Sprintf("SELECT %s FROM addr_inno WHERE id=%d;", pt, ID)).Scan(&lon, &lat)
+	err := db.QueryRow(fmt.Sprintf("SELECT %s FROM addr_inno WHERE id=?;", pt), ID).Scan(&lon, &lat)
 	if err != nil {
 		panic(err)
 	}
@@ -223,7 +258,7 @@ func GetNextID(startingID int, offset int, startingLon, startingLat float64, rnd
 			continue
 		}
 
-		err := db.QueryRow(fmt.Sprintf("SELECT lon, lat FROM addr_inno WHERE id=%d;", nextID)).Scan(&lon, &lat)
+		err := db.QueryRow("SELECT lon, lat FROM addr_inno WHERE id=?;", nextID).Scan(&lon, &lat)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				if !alreadyFlipped {
@@ -310,7 +345,7 @@ func GetCluster(offset, clusterSize int, rnd *rand.Rand, db *sql.DB) (int, []Clu
 		anchor = rnd.Intn(populationSize-1) + 1
 		fmt.Printf("%d rows near %d...", clusterSize, anchor)
 		rLon, rLat := GetLonLat(anchor, true, db)
-		c := GetClusteredRows(rLon, rLat, 25, db)
+		c := GetClusteredRows(rLon, rLat, 25, clusterSize*ClusterPool, db)
 		numRows := len(c)
 		if numRows < clusterSize {
 			fmt.Printf("too small (%d), retrying...", len(c))
@@ -328,7 +363,7 @@ func GetCluster(offset, clusterSize int, rnd *rand.Rand, db *sql.DB) (int, []Clu
 // InsertFeed inserts a Feed into the feed table
 func InsertFeed(feed *Feed, db *sql.DB) {
 	// Insert the feed
-	query := fmt.Sprintf("INSERT INTO feed ( user_id, slug, category, content, image1, image2, image3, image4, image5, reactions_happy, reactions_love, reactions_funny, reactions_shocked, reactions_sad, reactions_angry, lat, lng, reviewed, date_created) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', %d, %d, %d, %d, %d, %d, %f, %f, %d, '%s');",
+	_, err := db.Exec(InsertFeedQuery,
 		feed.UserID,
 		feed.Slug,
 		feed.Category,
@@ -347,9 +382,8 @@ func InsertFeed(feed *Feed, db *sql.DB) {
 		feed.Lat, feed.Lng,
 		feed.Reviewed,
 		feed.DateCreated.UTC().Format("2006-01-02 15:04:05"))
-	_, err := db.Exec(query)
 	if err != nil {
-		fmt.Println(query)
+		fmt.Println(InsertFeedQuery)
 		panic(err)
 	}
 }
@@ -357,15 +391,14 @@ func InsertFeed(feed *Feed, db *sql.DB) {
 // InsertComment inserts a Feed into the feed table
 func InsertComment(comment *Comment, db *sql.DB) {
 	// Insert the feed
-	query := fmt.Sprintf("INSERT INTO comments (parent_id, user_id, content, reviewed, date_created) VALUES (%d, %d, '%s', %d, '%s');",
+	_, err := db.Exec(InsertCommentQuery,
 		comment.ParentID,
 		comment.UserID,
 		comment.Content,
 		comment.Reviewed,
 		comment.DateCreated.UTC().Format("2006-01-02 15:04:05"))
-	_, err := db.Exec(query)
 	if err != nil {
-		fmt.Println(query)
+		fmt.Println(InsertCommentQuery)
 		panic(err)
 	}
 }
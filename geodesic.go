@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Ellipsoid is an ellipsoid of revolution modelling the Earth.
+type Ellipsoid struct {
+	A float64 // equatorial radius in kilometers
+	F float64 // flattening
+}
+
+// WGS84 is the World Geodetic System 1984 ellipsoid, used by GPS and by
+// SRID 4326.
+var WGS84 = Ellipsoid{6378.137, 1 / 298.257223563}
+
+// B returns the polar radius in kilometers.
+func (e Ellipsoid) B() float64 {
+	return e.A * (1 - e.F)
+}
+
+// Geodesic is the shortest path between two points on an ellipsoid.
+type Geodesic struct {
+	Distance float64 // length in kilometers
+	Azimuth1 float64 // bearing at the first point, in degrees clockwise from north in (-180, 180]
+	Azimuth2 float64 // bearing at the second point, continuing in the same direction
+}
+
+// ErrNoConvergence is returned by Vincenty when its iteration fails to
+// converge, which happens for nearly antipodal points.
+var ErrNoConvergence = errors.New("vincenty: no convergence for nearly antipodal points")
+
+// vincentyMaxIterations bounds the iteration of Vincenty. Away from the
+// antipode it converges in a handful.
+const vincentyMaxIterations = 200
+
+// Vincenty solves the inverse geodesic problem from p to q with Vincenty's
+// iterative formulae, which are accurate to well under a millimeter. For
+// nearly antipodal points the iteration may fail to converge, and it
+// returns ErrNoConvergence; use Inverse to fall back to Karney.
+//
+// See T. Vincenty, Survey Review 23(176), 88-93 (1975)
+func (e Ellipsoid) Vincenty(p, q Point) (Geodesic, error) {
+	b := e.B()
+	L := rad(math.Remainder(q.Lon-p.Lon, 360))
+	U1 := math.Atan((1 - e.F) * math.Tan(rad(p.Lat)))
+	U2 := math.Atan((1 - e.F) * math.Tan(rad(q.Lat)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinLambda, cosLambda, sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < vincentyMaxIterations; i++ {
+		sinLambda, cosLambda = math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			// The points coincide.
+			return Geodesic{}, nil
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cos2Alpha != 0 {
+			// Otherwise the line is along the equator.
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		C := e.F / 16 * cos2Alpha * (4 + e.F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-C)*e.F*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda) > math.Pi {
+			// Lambda has run away, as it does near the antipode.
+			break
+		}
+		if math.Abs(lambda-prev) < 1e-12 {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return Geodesic{}, ErrNoConvergence
+	}
+
+	uSq := cos2Alpha * (e.A*e.A - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return Geodesic{
+		Distance: b * A * (sigma - deltaSigma),
+		Azimuth1: deg(math.Atan2(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)),
+		Azimuth2: deg(math.Atan2(cosU1*sinLambda, -sinU1*cosU2+cosU1*sinU2*cosLambda)),
+	}, nil
+}
+
+// Inverse solves the inverse geodesic problem from p to q with Vincenty,
+// falling back to Karney for the nearly antipodal points where Vincenty
+// does not converge.
+func (e Ellipsoid) Inverse(p, q Point) Geodesic {
+	g, err := e.Vincenty(p, q)
+	if err != nil {
+		return e.Karney(p, q)
+	}
+	return g
+}
+
+// Direct solves the direct geodesic problem with Vincenty's formulae: it
+// returns the point reached from p by travelling distance kilometers along
+// the geodesic that leaves p on azimuth degrees, and the azimuth on arrival.
+// Unlike the inverse problem, the iteration converges for every distance
+// up to half way round the ellipsoid.
+func (e Ellipsoid) Direct(p Point, azimuth, distance float64) (Point, float64) {
+	b := e.B()
+	sinAlpha1, cosAlpha1 := math.Sincos(rad(azimuth))
+	tanU1 := (1 - e.F) * math.Tan(rad(p.Lat))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cos2Alpha := 1 - sinAlpha*sinAlpha
+	uSq := cos2Alpha * (e.A*e.A - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+	sigma := distance / (b * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+	for i := 0; i < vincentyMaxIterations; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sincos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		prev := sigma
+		sigma = distance/(b*A) + deltaSigma
+		if math.Abs(sigma-prev) < 1e-12 {
+			break
+		}
+	}
+	cos2SigmaM = math.Cos(2*sigma1 + sigma)
+	sinSigma, cosSigma = math.Sincos(sigma)
+
+	x := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	lat := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1, (1-e.F)*math.Hypot(sinAlpha, x))
+	lambda := math.Atan2(sinSigma*sinAlpha1, cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	C := e.F / 16 * cos2Alpha * (4 + e.F*(4-3*cos2Alpha))
+	L := lambda - (1-C)*e.F*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+	return Point{normalizeLon(p.Lon + deg(L)), deg(lat)}, deg(math.Atan2(sinAlpha, -x))
+}
+
+// Intermediate returns the point the given fraction of the way along the
+// geodesic from p to q.
+func (e Ellipsoid) Intermediate(p, q Point, fraction float64) Point {
+	g := e.Inverse(p, q)
+	point, _ := e.Direct(p, g.Azimuth1, fraction*g.Distance)
+	return point
+}
+
+// Circle returns n points evenly spaced in azimuth around the circle of
+// points radius kilometers from center along geodesics, the exact boundary
+// of a radius search.
+func (e Ellipsoid) Circle(center Point, radius float64, n int) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		points[i], _ = e.Direct(center, 360*float64(i)/float64(n), radius)
+	}
+	return points
+}
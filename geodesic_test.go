@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// geodesicTests are inverse problems on WGS84 with published solutions:
+// the examples of Vincenty (1975), of Karney (2013) and of GeographicLib's
+// GeodSolve, and the quadrants of the equator and of a meridian.
+var geodesicTests = []struct {
+	name               string
+	p, q               Point
+	distance           float64 // kilometers
+	azimuth1, azimuth2 float64
+	azimuthTolerance   float64 // degrees
+	antipodal          bool    // where Vincenty does not converge
+}{
+	// Flinders Peak to Buninyong, given to a hundredth of an arc second.
+	{"Flinders Peak to Buninyong", Point{144.424867889, -37.951033417}, Point{143.926495528, -37.652821139},
+		54.972271, -53.131841667, -52.826369444, 1e-5, false},
+	{"JFK to LHR", Point{-73.8, 40.6}, Point{-0.5, 51.6}, 5551.759400319, 51.198882845, 107.821776735, 1e-8, false},
+	{"equator quadrant", Point{0, 0}, Point{90, 0}, 10018.754171394621, 90, 90, 1e-9, false},
+	{"meridian quadrant", Point{0, 0}, Point{0, 90}, 10001.965729312724, 0, 0, 1e-9, false},
+	{"Karney's antipodal example", Point{0, -30}, Point{179.8, 29.9}, 19989.832827610, 161.890524736, 18.090737246, 1e-8, true},
+}
+
+// degreesApart returns the difference between two angles in degrees.
+func degreesApart(a, b float64) float64 {
+	return math.Abs(math.Remainder(a-b, 360))
+}
+
+func TestKarney(t *testing.T) {
+	for _, test := range geodesicTests {
+		g := WGS84.Karney(test.p, test.q)
+		if math.Abs(g.Distance-test.distance) > 1e-6 {
+			t.Errorf("%s: distance %.9f km, want %.9f", test.name, g.Distance, test.distance)
+		}
+		if degreesApart(g.Azimuth1, test.azimuth1) > test.azimuthTolerance || degreesApart(g.Azimuth2, test.azimuth2) > test.azimuthTolerance {
+			t.Errorf("%s: azimuths %.9f, %.9f, want %.9f, %.9f", test.name, g.Azimuth1, g.Azimuth2, test.azimuth1, test.azimuth2)
+		}
+	}
+}
+
+func TestVincenty(t *testing.T) {
+	for _, test := range geodesicTests {
+		g, err := WGS84.Vincenty(test.p, test.q)
+		if test.antipodal {
+			if err != ErrNoConvergence {
+				t.Errorf("%s: Vincenty returned %v, want ErrNoConvergence", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if math.Abs(g.Distance-test.distance) > 1e-6 {
+			t.Errorf("%s: distance %.9f km, want %.9f", test.name, g.Distance, test.distance)
+		}
+
+		// Inverse falls back to Karney where Vincenty fails.
+		g = WGS84.Inverse(test.p, test.q)
+		if math.Abs(g.Distance-test.distance) > 1e-6 {
+			t.Errorf("%s: Inverse distance %.9f km, want %.9f", test.name, g.Distance, test.distance)
+		}
+		if degreesApart(g.Azimuth1, test.azimuth1) > test.azimuthTolerance || degreesApart(g.Azimuth2, test.azimuth2) > test.azimuthTolerance {
+			t.Errorf("%s: Inverse azimuths %.9f, %.9f, want %.9f, %.9f", test.name, g.Azimuth1, g.Azimuth2, test.azimuth1, test.azimuth2)
+		}
+	}
+}
+
+func TestDirect(t *testing.T) {
+	// Karney's example of the direct problem.
+	p, azimuth := WGS84.Direct(Point{0, 40}, 30, 10000)
+	if degreesApart(p.Lon, 137.84490004377) > 1e-8 || math.Abs(p.Lat-41.79331020506) > 1e-8 || degreesApart(azimuth, 149.09016931807) > 1e-8 {
+		t.Errorf("Direct reached %v on azimuth %.11f, want {137.84490004377 41.79331020506} on 149.09016931807", p, azimuth)
+	}
+}
+
+// TestGeodTest checks Karney against GeographicLib's test set of geodesics,
+// GeodTest-short.dat, if it has been downloaded, from
+// https://sourceforge.net/projects/geographiclib/files/testdata/, into
+// testdata. Its lines are lat1 lon1 azi1 lat2 lon2 azi2 s12 a12 m12 S12,
+// with s12 in meters.
+func TestGeodTest(t *testing.T) {
+	var in io.Reader
+	f, err := os.Open("testdata/GeodTest-short.dat.gz")
+	if err == nil {
+		defer f.Close()
+		if in, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	} else if f, err = os.Open("testdata/GeodTest-short.dat"); err == nil {
+		defer f.Close()
+		in = f
+	} else {
+		t.Skip("testdata/GeodTest-short.dat not downloaded")
+	}
+
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			t.Fatalf("line %d: %d fields", line, len(fields))
+		}
+		var v [7]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				t.Fatalf("line %d: %v", line, err)
+			}
+		}
+		p, q, distance := Point{v[1], v[0]}, Point{v[4], v[3]}, v[6]/1000
+		g := WGS84.Karney(p, q)
+		if math.Abs(g.Distance-distance) > 1e-8 {
+			t.Errorf("line %d: distance %.12f km, want %.12f", line, g.Distance, distance)
+		}
+		// Nearly antipodal points are joined by more than one geodesic,
+		// and the azimuths at the poles depend on the longitudes given.
+		if distance < 19900 && math.Abs(p.Lat) < 90 && math.Abs(q.Lat) < 90 {
+			if degreesApart(g.Azimuth1, v[2]) > 1e-7 || degreesApart(g.Azimuth2, v[5]) > 1e-7 {
+				t.Errorf("line %d: azimuths %.9f, %.9f, want %.9f, %.9f", line, g.Azimuth1, g.Azimuth2, v[2], v[5])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
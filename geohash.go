@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// geohashAlphabet is the geohash base 32 alphabet, which leaves out a, i, l
+// and o.
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeohashPrecision is the length of the geohashes the loader writes, about
+// 37 mm by 19 mm, and the width of the geohash column.
+const GeohashPrecision = 12
+
+// geohashMaxCover bounds the number of cells GeohashCover returns. Each one
+// becomes a range scan on the geohash index.
+const geohashMaxCover = 32
+
+// geohashBits returns the number of longitude and latitude bits in a geohash
+// of precision characters. Bits alternate, longitude first.
+func geohashBits(precision int) (lonBits, latBits uint) {
+	bits := uint(5 * precision)
+	return (bits + 1) / 2, bits / 2
+}
+
+// geohashCellSize returns the width and height in degrees of the cells of
+// geohashes of precision characters.
+func geohashCellSize(precision int) (float64, float64) {
+	lonBits, latBits := geohashBits(precision)
+	return 360 / float64(uint64(1)<<lonBits), 180 / float64(uint64(1)<<latBits)
+}
+
+// geohashIndex returns the column x and row y of the cell containing p among
+// the cells of geohashes of precision characters.
+func geohashIndex(p Point, precision int) (x, y uint64) {
+	lonBits, latBits := geohashBits(precision)
+	index := func(v, min, span float64, bits uint) uint64 {
+		n := uint64(1) << bits
+		i := math.Floor((v - min) / span * float64(n))
+		if i < 0 {
+			return 0
+		}
+		if i >= float64(n) {
+			return n - 1
+		}
+		return uint64(i)
+	}
+	return index(p.Lon, -180, 360, lonBits), index(p.Lat, -90, 180, latBits)
+}
+
+// geohashFromIndex returns the geohash of the cell in column x and row y.
+func geohashFromIndex(x, y uint64, precision int) string {
+	lonBits, latBits := geohashBits(precision)
+	hash := make([]byte, precision)
+	for i := range hash {
+		var c byte
+		for b := 0; b < 5; b++ {
+			k := uint(5*i + b)
+			var bit uint64
+			if k%2 == 0 {
+				bit = x >> (lonBits - 1 - k/2) & 1
+			} else {
+				bit = y >> (latBits - 1 - k/2) & 1
+			}
+			c = c<<1 | byte(bit)
+		}
+		hash[i] = geohashAlphabet[c]
+	}
+	return string(hash)
+}
+
+// GeohashEncode returns the geohash of p with precision characters, from 1
+// to GeohashPrecision.
+func GeohashEncode(p Point, precision int) string {
+	x, y := geohashIndex(p, precision)
+	return geohashFromIndex(x, y, precision)
+}
+
+// geohashDecodeIndex returns the column and row of the cell of hash.
+func geohashDecodeIndex(hash string) (x, y uint64, err error) {
+	if len(hash) == 0 || len(hash) > GeohashPrecision {
+		return 0, 0, fmt.Errorf("geohash %q must have 1 to %d characters", hash, GeohashPrecision)
+	}
+	for i := 0; i < len(hash); i++ {
+		c := strings.IndexByte(geohashAlphabet, hash[i])
+		if c < 0 {
+			return 0, 0, fmt.Errorf("geohash %q: invalid character %q", hash, hash[i])
+		}
+		for b := 4; b >= 0; b-- {
+			bit := uint64(c>>uint(b)) & 1
+			if (5*i+4-b)%2 == 0 {
+				x = x<<1 | bit
+			} else {
+				y = y<<1 | bit
+			}
+		}
+	}
+	return x, y, nil
+}
+
+// GeohashDecode returns the cell of hash.
+func GeohashDecode(hash string) (Box, error) {
+	x, y, err := geohashDecodeIndex(hash)
+	if err != nil {
+		return Box{}, err
+	}
+	w, h := geohashCellSize(len(hash))
+	return Box{
+		MinLon: -180 + float64(x)*w,
+		MinLat: -90 + float64(y)*h,
+		MaxLon: -180 + float64(x+1)*w,
+		MaxLat: -90 + float64(y+1)*h,
+	}, nil
+}
+
+// geohashDirections are the offsets of the neighbors of a cell, in columns
+// and rows, clockwise from north.
+var geohashDirections = [8][2]int64{{0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}, {-1, -1}, {-1, 0}, {-1, 1}}
+
+// GeohashNeighbors returns the geohashes of the eight cells around hash,
+// clockwise from north: N, NE, E, SE, S, SW, W and NW. Neighbors wrap
+// across the antimeridian; beyond a pole there are none, and those are
+// empty.
+func GeohashNeighbors(hash string) ([8]string, error) {
+	var neighbors [8]string
+	x, y, err := geohashDecodeIndex(hash)
+	if err != nil {
+		return neighbors, err
+	}
+	lonBits, latBits := geohashBits(len(hash))
+	columns, rows := int64(1)<<lonBits, int64(1)<<latBits
+	for i, d := range geohashDirections {
+		ny := int64(y) + d[1]
+		if ny < 0 || ny >= rows {
+			continue
+		}
+		nx := (int64(x) + d[0] + columns) % columns
+		neighbors[i] = geohashFromIndex(uint64(nx), uint64(ny), len(hash))
+	}
+	return neighbors, nil
+}
+
+// geohashBoxCells returns the number of cells of precision characters that
+// the boxes overlap.
+func geohashBoxCells(boxes []Box, precision int) int {
+	count := 0
+	for _, b := range boxes {
+		x0, y0 := geohashIndex(Point{b.MinLon, b.MinLat}, precision)
+		x1, y1 := geohashIndex(Point{b.MaxLon, b.MaxLat}, precision)
+		count += int((x1 - x0 + 1) * (y1 - y0 + 1))
+	}
+	return count
+}
+
+// GeohashCover returns geohash prefixes whose cells together contain every
+// point within radius kilometers of p. It uses the longest prefixes for
+// which no more than geohashMaxCover cells cover the circle's bounding boxes.
+func GeohashCover(p Point, radius float64) []string {
+	boxes := BoundingBoxes(p, radius)
+	precision := GeohashPrecision
+	for precision > 1 && geohashBoxCells(boxes, precision) > geohashMaxCover {
+		precision--
+	}
+
+	var cover []string
+	seen := make(map[string]bool)
+	for _, b := range boxes {
+		x0, y0 := geohashIndex(Point{b.MinLon, b.MinLat}, precision)
+		x1, y1 := geohashIndex(Point{b.MaxLon, b.MaxLat}, precision)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				hash := geohashFromIndex(x, y, precision)
+				if !seen[hash] {
+					seen[hash] = true
+					cover = append(cover, hash)
+				}
+			}
+		}
+	}
+	return cover
+}
+
+// GeohashWhere renders a WHERE condition matching the rows whose geohash
+// column starts with any of prefixes, and the arguments to bind to it. A
+// LIKE with a constant prefix is a range scan on the geohash index.
+func GeohashWhere(prefixes []string) (string, []interface{}) {
+	terms := make([]string, len(prefixes))
+	args := make([]interface{}, len(prefixes))
+	for i, prefix := range prefixes {
+		terms[i] = "geohash LIKE ?"
+		args[i] = prefix + "%"
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+func init() {
+	RegisterStrategy(&templateStrategy{
+		name:        "geohash",
+		description: "geohash prefixes covering the circle on the indexed geohash column, then the law of cosines",
+		requires:    []Requirement{{"column", "geohash"}, {"index", "geohash"}, {"column", "lon"}, {"column", "lat"}},
+		distance:    InlineDistance,
+		args:        latLonLat,
+		prefilter: func(p Point, radiusKm float64, _ int) (string, []interface{}) {
+			return GeohashWhere(GeohashCover(p, radiusKm))
+		},
+	})
+}
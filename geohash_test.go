@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestGeohashEncode(t *testing.T) {
+	tests := []struct {
+		p         Point
+		precision int
+		hash      string
+	}{
+		// The examples on Wikipedia, then the corners of the grid and of the
+		// cells at the origin.
+		{Point{10.40744, 57.64911}, 11, "u4pruydqqvj"},
+		{Point{-5.6, 42.6}, 5, "ezs42"},
+		{Point{-180, -90}, 12, "000000000000"},
+		{Point{179.9999999, 89.9999999}, 12, "zzzzzzzzzzzz"},
+		{Point{0, 0}, 6, "s00000"},
+		{Point{-0.0000001, -0.0000001}, 6, "7zzzzz"},
+	}
+	for _, test := range tests {
+		if hash := GeohashEncode(test.p, test.precision); hash != test.hash {
+			t.Errorf("GeohashEncode(%v, %d) = %s, want %s", test.p, test.precision, hash, test.hash)
+		}
+		b, err := GeohashDecode(test.hash)
+		if err != nil {
+			t.Errorf("GeohashDecode(%s): %v", test.hash, err)
+		} else if !b.Contains(test.p) {
+			t.Errorf("GeohashDecode(%s) = %v, which does not contain %v", test.hash, b, test.p)
+		}
+	}
+	for _, hash := range []string{"", "ezs4a", "ezs4i", "ezs4l", "ezs4o", "u4pruydqqvjabc"} {
+		if _, err := GeohashDecode(hash); err == nil {
+			t.Errorf("GeohashDecode(%q) succeeded", hash)
+		}
+	}
+}
+
+func TestGeohashNeighbors(t *testing.T) {
+	tests := []struct {
+		hash      string
+		neighbors [8]string
+	}{
+		{"ezs42", [8]string{"ezs48", "ezs49", "ezs43", "ezs41", "ezs40", "ezefp", "ezefr", "ezefx"}},
+		// The cell at the north west corner has its western neighbors
+		// across the antimeridian, and none to the north.
+		{"b", [8]string{"", "", "c", "9", "8", "x", "z", ""}},
+		{"0", [8]string{"2", "3", "1", "", "", "", "p", "r"}},
+	}
+	for _, test := range tests {
+		neighbors, err := GeohashNeighbors(test.hash)
+		if err != nil {
+			t.Errorf("GeohashNeighbors(%s): %v", test.hash, err)
+		} else if neighbors != test.neighbors {
+			t.Errorf("GeohashNeighbors(%s) = %q, want %q", test.hash, neighbors, test.neighbors)
+		}
+	}
+
+	// Each neighbor is the cell one cell width or height away, across the
+	// antimeridian too, and none beyond a pole.
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		p := randomPoint(rnd)
+		switch n % 4 {
+		case 0:
+			p.Lon = 179.9999999
+		case 1:
+			p.Lon = -180
+		case 2:
+			p.Lat = 89.9999999
+		}
+		precision := 1 + n%GeohashPrecision
+		hash := GeohashEncode(p, precision)
+		neighbors, err := GeohashNeighbors(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := GeohashDecode(hash)
+		w, h := geohashCellSize(precision)
+		center := Point{(b.MinLon + b.MaxLon) / 2, (b.MinLat + b.MaxLat) / 2}
+		for i, d := range geohashDirections {
+			q := Point{normalizeLon(center.Lon + float64(d[0])*w), center.Lat + float64(d[1])*h}
+			want := ""
+			if q.Lat > -90 && q.Lat < 90 {
+				want = GeohashEncode(q, precision)
+			}
+			if neighbors[i] != want {
+				t.Fatalf("neighbor %d of %s, the cell of %v, is %q, want %q", i, hash, p, neighbors[i], want)
+			}
+		}
+	}
+}
+
+func TestGeohashCover(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for n := 0; n < 600; n++ {
+		p := randomPoint(rnd)
+		switch n % 10 {
+		case 0:
+			p.Lon = 179.99
+		case 1:
+			p.Lon = -179.99
+		case 2:
+			p.Lat = 89.9
+		case 3:
+			p.Lat = -89.99
+		}
+		radius := []float64{0.01, 0.5, 3, 25, 300, 3000}[n%6]
+		cover := GeohashCover(p, radius)
+		if len(cover) > geohashMaxCover {
+			t.Fatalf("the cover of %g km around %v has %d prefixes", radius, p, len(cover))
+		}
+		for k := 0; k < 200; k++ {
+			// Half the points are on the rim of the circle.
+			distance := radius * rnd.Float64()
+			if k%2 == 0 {
+				distance = radius * (1 - 1e-9)
+			}
+			q := Destination(p, rnd.Float64()*360, distance)
+			hash := GeohashEncode(q, GeohashPrecision)
+			covered := false
+			for _, prefix := range cover {
+				covered = covered || strings.HasPrefix(hash, prefix)
+			}
+			if !covered {
+				t.Fatalf("%v (%s), %g km from %v, has none of the prefixes %v", q, hash, distance, p, cover)
+			}
+		}
+	}
+}
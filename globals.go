@@ -3,5 +3,6 @@ package main
 var MaxDays = 5
 var CommenterOffset = 5000
 var MaxComments = 10
+var ClusterPool = 4
 var MaxFeedParagraphs = 10
 var MaxContentParagraphs = 3
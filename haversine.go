@@ -4,16 +4,20 @@ import (
 	"math"
 )
 
-// Mean radius of Earth in kilometers
-var Rk = 6371.0
+// Mean radius of Earth in kilometers, from MeanEarth
+var Rk = MeanEarth.Radius.Kilometers()
 
-// Mean radius of Earth in miles
-var Rm = 3959.0
+// Mean radius of Earth in miles, from MeanEarth
+var Rm = MeanEarth.Radius.Miles()
 
 func rad(deg float64) float64 {
 	return deg * math.Pi / 180
 }
 
+func deg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
 // haversin(θ) function
 func hav(theta float64) float64 {
 	return .5 * (1 - math.Cos(theta))
@@ -50,3 +54,70 @@ func Distance2(dlon, dlat, dtlon, dtlat float64) float64 {
 
 	return 2 * Rk * math.Asin(math.Sqrt(h))
 }
+
+// Destination returns the point reached from p by travelling distance
+// kilometers on the sphere, starting on bearing degrees clockwise from north.
+func Destination(p Point, bearing, distance float64) Point {
+	lat := rad(p.Lat)
+	theta := rad(bearing)
+	delta := distance / Rk
+
+	lat2 := math.Asin(math.Sin(lat)*math.Cos(delta) + math.Cos(lat)*math.Sin(delta)*math.Cos(theta))
+	dlon := math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat), math.Cos(delta)-math.Sin(lat)*math.Sin(lat2))
+	return Point{normalizeLon(p.Lon + deg(dlon)), deg(lat2)}
+}
+
+// InitialBearing returns the bearing, in degrees clockwise from north in
+// [0, 360), on which the great circle from p to q leaves p.
+func InitialBearing(p, q Point) float64 {
+	lat1 := rad(p.Lat)
+	lat2 := rad(q.Lat)
+	dlon := rad(q.Lon - p.Lon)
+
+	y := math.Sin(dlon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dlon)
+	return math.Mod(deg(math.Atan2(y, x))+360, 360)
+}
+
+// FinalBearing returns the bearing, in degrees clockwise from north in
+// [0, 360), on which the great circle from p to q arrives at q.
+func FinalBearing(p, q Point) float64 {
+	return math.Mod(InitialBearing(q, p)+180, 360)
+}
+
+// Midpoint returns the point halfway along the great circle from p to q.
+func Midpoint(p, q Point) Point {
+	lat1 := rad(p.Lat)
+	lat2 := rad(q.Lat)
+	dlon := rad(q.Lon - p.Lon)
+
+	bx := math.Cos(lat2) * math.Cos(dlon)
+	by := math.Cos(lat2) * math.Sin(dlon)
+	lat := math.Atan2(math.Sin(lat1)+math.Sin(lat2), math.Hypot(math.Cos(lat1)+bx, by))
+	lon := rad(p.Lon) + math.Atan2(by, math.Cos(lat1)+bx)
+	return Point{normalizeLon(deg(lon)), deg(lat)}
+}
+
+// Intermediate returns the point the given fraction of the way along the
+// great circle from p to q. The great circle between antipodal points is
+// undefined.
+func Intermediate(p, q Point, fraction float64) Point {
+	lat1, lon1 := rad(p.Lat), rad(p.Lon)
+	lat2, lon2 := rad(q.Lat), rad(q.Lon)
+	delta := Distance2(p.Lon, p.Lat, q.Lon, q.Lat) / Rk
+	if delta == 0 {
+		return p
+	}
+
+	a := math.Sin((1-fraction)*delta) / math.Sin(delta)
+	b := math.Sin(fraction*delta) / math.Sin(delta)
+	x := a*math.Cos(lat1)*math.Cos(lon1) + b*math.Cos(lat2)*math.Cos(lon2)
+	y := a*math.Cos(lat1)*math.Sin(lon1) + b*math.Cos(lat2)*math.Sin(lon2)
+	z := a*math.Sin(lat1) + b*math.Sin(lat2)
+	return Point{deg(math.Atan2(y, x)), deg(math.Atan2(z, math.Hypot(x, y)))}
+}
+
+// normalizeLon reduces a longitude in degrees to [-180, 180].
+func normalizeLon(lon float64) float64 {
+	return math.Remainder(lon, 360)
+}
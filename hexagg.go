@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// HexSources maps the sources hexbin can aggregate to the queries that
+// select their lon and lat. The address query is formatted with the table.
+var HexSources = map[string]string{
+	"addresses": "SELECT lon, lat FROM %s;",
+	"feed":      "SELECT lng, lat FROM feed;",
+}
+
+// HexCount is the number of rows that fall in a hex cell.
+type HexCount struct {
+	Cell   HexCell
+	Count  int
+	Sample Point // the first row found in the cell
+}
+
+// Density returns the rows per square kilometer in the cell, over the area
+// left of it if it is clipped.
+func (c HexCount) Density() float64 {
+	return float64(c.Count) / c.Cell.Area()
+}
+
+// AggregateHex counts the rows selected by query, which selects lon and lat,
+// into the cells of res. The cells are returned densest first, and ties in
+// order of cell. It also returns the number of rows read.
+func AggregateHex(query string, res int, db *sql.DB) ([]HexCount, int, error) {
+	if res < 0 || res > HexMaxResolution {
+		return nil, 0, fmt.Errorf("hex resolution %d must be from 0 to %d", res, HexMaxResolution)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	counts := make(map[HexCell]*HexCount)
+	total := 0
+	for rows.Next() {
+		var p Point
+		if err := rows.Scan(&p.Lon, &p.Lat); err != nil {
+			return nil, total, err
+		}
+		total++
+		cell := HexCellFromPoint(p, res)
+		if c, ok := counts[cell]; ok {
+			c.Count++
+			continue
+		}
+		counts[cell] = &HexCount{cell, 1, p}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, total, err
+	}
+
+	cells := make([]HexCount, 0, len(counts))
+	for _, c := range counts {
+		cells = append(cells, *c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		return cells[i].Cell < cells[j].Cell
+	})
+	return cells, total, nil
+}
+
+// DensityAnchors picks n anchor points from the densest or the sparsest
+// cells of res holding rows of table: one row from each cell.
+func DensityAnchors(n int, dense bool, res int, table string, db *sql.DB) ([]Point, error) {
+	cells, _, err := AggregateHex(fmt.Sprintf(HexSources["addresses"], QuoteTable(table)), res, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("table %s is empty, no anchors to pick", table)
+	}
+	if !dense {
+		sort.SliceStable(cells, func(i, j int) bool { return cells[i].Count < cells[j].Count })
+	}
+	if n > len(cells) {
+		n = len(cells)
+	}
+	anchors := make([]Point, n)
+	for i := range anchors {
+		anchors[i] = cells[i].Sample
+	}
+	return anchors, nil
+}
+
+// writeHexCounts writes the cells to a CSV file, with their boundaries as
+// WKT polygons.
+func writeHexCounts(path string, cells []HexCount) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"cell", "resolution", "lon", "lat", "rows", "rows_per_km2", "clipped", "boundary"})
+	for _, c := range cells {
+		center := c.Cell.Center()
+		w.Write([]string{
+			c.Cell.String(),
+			strconv.Itoa(c.Cell.Resolution()),
+			formatCoord(center.Lon),
+			formatCoord(center.Lat),
+			strconv.Itoa(c.Count),
+			strconv.FormatFloat(c.Density(), 'f', 3, 64),
+			strconv.FormatBool(c.Cell.Clipped()),
+			c.Cell.WKT(),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// HexBin aggregates the addresses or feed posts into hex cells and reports
+// the densest
+func (gc *GeoCommand) HexBin(context *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+
+	query := HexSources[gc.HexSource]
+	if gc.HexSource == "addresses" {
+		query = fmt.Sprintf(query, QuoteTable(gc.Table))
+	}
+	cells, total, err := AggregateHex(query, gc.Resolution, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v rows in %v cells at resolution %d, of %.6g km2 and edges of %.4g km\n",
+		Green("%d", total), Green("%d", len(cells)), gc.Resolution, HexArea(gc.Resolution), HexEdgeLength(gc.Resolution))
+	if len(cells) == 0 {
+		return nil
+	}
+
+	counts := make([]float64, len(cells))
+	for i, c := range cells {
+		counts[len(cells)-1-i] = float64(c.Count)
+	}
+	fmt.Printf("Rows per cell: min %v, median %v, p95 %v, max %v\n\n", counts[0],
+		percentile(counts, 50), percentile(counts, 95), counts[len(counts)-1])
+
+	top := cells
+	if gc.Top > 0 && gc.Top < len(top) {
+		top = top[:gc.Top]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "cell\tlon\tlat\trows\trows/km2\t")
+	for _, c := range top {
+		center := c.Cell.Center()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f\t\n", c.Cell, formatCoord(center.Lon), formatCoord(center.Lat), c.Count, c.Density())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if gc.HexFile != "" {
+		if err := writeHexCounts(gc.HexFile, cells); err != nil {
+			return err
+		}
+		fmt.Printf("\nWrote %d cells to %s\n", len(cells), Cyan("%s", gc.HexFile))
+	}
+	return nil
+}
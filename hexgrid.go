@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// HexCell identifies a cell of a hierarchical hexagonal grid modelled on
+// Uber's H3. The grid is laid out on the Gall-Peters projection, which
+// preserves area, so every cell of a resolution covers the same area of the
+// sphere; shapes are true at 45 degrees north and south, and stretched away
+// from them. Each resolution has cells a seventh the area of the one before,
+// rotated by about 19.1 degrees, alternately one way and back, like H3's
+// Class II and III resolutions. Each cell has seven children: one at its
+// center and its six neighbors. As in H3, the children only approximately
+// cover their parent, but the hierarchy of indexes is exact.
+//
+// The top four bits are the resolution, followed by the cell's two axial
+// lattice coordinates as 30-bit two's complement integers. The grid does not
+// wrap at the antimeridian, where cells are cut in two, and cells are cut
+// off at the poles too. Such cells are clipped to the edges of the
+// projection: their boundary, center and area are those of the part on it.
+type HexCell uint64
+
+// HexMaxResolution is the finest resolution, with cells of about a square
+// meter.
+const HexMaxResolution = 15
+
+// hexRes0Area is the area in square kilometers of the cells of resolution
+// 0, the average area of H3's.
+const hexRes0Area = 4357449.416
+
+// hexStandardParallel is the latitude, in degrees, at which the projection
+// keeps shapes.
+const hexStandardParallel = 45
+
+// hexCoordBits is the width of each lattice coordinate in a HexCell.
+const hexCoordBits = 30
+
+// hexUnits are the axial offsets of the six neighbors of a cell,
+// counterclockwise from east.
+var hexUnits = [6][2]int64{{1, 0}, {0, 1}, {-1, 1}, {-1, 0}, {0, -1}, {1, -1}}
+
+// hexAperture returns the lattice number, of norm 7, that the cells of res
+// are multiplied by to give the centers of their children: 2 + w from even
+// resolutions, and its conjugate 3 - w from odd ones, where w is the unit at
+// 60 degrees.
+func hexAperture(res int) [2]int64 {
+	if res%2 == 0 {
+		return [2]int64{2, 1}
+	}
+	return [2]int64{3, -1}
+}
+
+// hexMultiply multiplies lattice numbers a + b w, using w * w = w - 1.
+func hexMultiply(x, y [2]int64) [2]int64 {
+	return [2]int64{x[0]*y[0] - x[1]*y[1], x[0]*y[1] + x[1]*y[0] + x[1]*y[1]}
+}
+
+// hexComplex returns the lattice number a + b w as a complex number.
+func hexComplex(a [2]float64) complex128 {
+	return complex(a[0]+a[1]/2, a[1]*math.Sqrt(3)/2)
+}
+
+// hexRound returns the lattice point nearest to z, by rounding its cube
+// coordinates.
+func hexRound(z complex128) [2]int64 {
+	b := imag(z) * 2 / math.Sqrt(3)
+	a := real(z) - b/2
+	c := -a - b
+	ra, rb, rc := math.Round(a), math.Round(b), math.Round(c)
+	da, db, dc := math.Abs(ra-a), math.Abs(rb-b), math.Abs(rc-c)
+	switch {
+	case da > db && da > dc:
+		ra = -rb - rc
+	case db > dc:
+		rb = -ra - rc
+	}
+	return [2]int64{int64(ra), int64(rb)}
+}
+
+// HexArea returns the area of the cells of res in square kilometers.
+func HexArea(res int) float64 {
+	return hexRes0Area / math.Pow(7, float64(res))
+}
+
+// hexSpacing returns the distance between neighboring cell centers of res on
+// the projection, in kilometers.
+func hexSpacing(res int) float64 {
+	return math.Sqrt(2 * HexArea(res) / math.Sqrt(3))
+}
+
+// HexEdgeLength returns the length of the edges of the cells of res on the
+// projection, in kilometers, which is their length on the sphere at 45
+// degrees.
+func HexEdgeLength(res int) float64 {
+	return hexSpacing(res) / math.Sqrt(3)
+}
+
+// hexOrientation returns the rotation of the lattice of res on the
+// projection.
+func hexOrientation(res int) complex128 {
+	if res%2 == 0 {
+		return 1
+	}
+	a := hexComplex([2]float64{2, 1})
+	return complex(real(a), -imag(a)) / complex(math.Sqrt(7), 0)
+}
+
+// hexProject returns the position of p on the projection, in kilometers.
+func hexProject(p Point) complex128 {
+	k := math.Cos(rad(hexStandardParallel))
+	return complex(Rk*rad(p.Lon)*k, Rk*math.Sin(rad(p.Lat))/k)
+}
+
+// hexBounds returns the half width and half height of the projection, in
+// kilometers: the positions of the antimeridian and of the north pole.
+func hexBounds() (x, y float64) {
+	k := math.Cos(rad(hexStandardParallel))
+	return Rk * math.Pi * k, Rk / k
+}
+
+// hexUnproject returns the point at position z, which must lie on the
+// projection.
+func hexUnproject(z complex128) Point {
+	k := math.Cos(rad(hexStandardParallel))
+	// Only rounding takes the sine of the latitude beyond 1.
+	sinLat := math.Max(-1, math.Min(1, imag(z)*k/Rk))
+	return Point{deg(real(z) / (Rk * k)), deg(math.Asin(sinLat))}
+}
+
+// hexClip clips a convex polygon on the projection to its edges, one edge at
+// a time, keeping the corners inside each and adding those where the
+// polygon's edges cross it.
+func hexClip(polygon []complex128) []complex128 {
+	x, y := hexBounds()
+	edges := []struct {
+		coord func(z complex128) float64
+		limit float64
+	}{
+		{func(z complex128) float64 { return real(z) }, x},
+		{func(z complex128) float64 { return -real(z) }, x},
+		{func(z complex128) float64 { return imag(z) }, y},
+		{func(z complex128) float64 { return -imag(z) }, y},
+	}
+	for _, e := range edges {
+		var clipped []complex128
+		for i, a := range polygon {
+			b := polygon[(i+1)%len(polygon)]
+			da, db := e.coord(a)-e.limit, e.coord(b)-e.limit
+			if da <= 0 {
+				clipped = append(clipped, a)
+			}
+			if (da < 0 && db > 0) || (da > 0 && db < 0) {
+				clipped = append(clipped, a+(b-a)*complex(da/(da-db), 0))
+			}
+		}
+		polygon = clipped
+	}
+	return polygon
+}
+
+// polygonArea returns the area and centroid of a polygon on the projection.
+// Its corners are taken relative to the first, as the products of positions
+// thousands of kilometers out would swamp the area of a small cell.
+func polygonArea(polygon []complex128) (float64, complex128) {
+	if len(polygon) == 0 {
+		return 0, 0
+	}
+	var area float64
+	var centroid complex128
+	origin := polygon[0]
+	for i := range polygon {
+		a, b := polygon[i]-origin, polygon[(i+1)%len(polygon)]-origin
+		cross := real(a)*imag(b) - real(b)*imag(a)
+		area += cross
+		centroid += (a + b) * complex(cross, 0)
+	}
+	if area == 0 {
+		return 0, 0
+	}
+	return area / 2, origin + centroid/complex(3*area, 0)
+}
+
+// newHexCell packs a resolution and lattice coordinates into a HexCell.
+func newHexCell(res int, a [2]int64) HexCell {
+	mask := uint64(1)<<hexCoordBits - 1
+	return HexCell(uint64(res)<<(2*hexCoordBits) | uint64(a[0])&mask<<hexCoordBits | uint64(a[1])&mask)
+}
+
+// HexCellFromPoint returns the cell of res containing p.
+func HexCellFromPoint(p Point, res int) HexCell {
+	z := hexProject(p) / hexOrientation(res) / complex(hexSpacing(res), 0)
+	return newHexCell(res, hexRound(z))
+}
+
+// Resolution returns the resolution of the cell, from 0 to HexMaxResolution.
+func (h HexCell) Resolution() int {
+	return int(h >> (2 * hexCoordBits))
+}
+
+// axial returns the lattice coordinates of the cell.
+func (h HexCell) axial() [2]int64 {
+	signed := func(v uint64) int64 {
+		v &= 1<<hexCoordBits - 1
+		return int64(v<<(64-hexCoordBits)) >> (64 - hexCoordBits)
+	}
+	return [2]int64{signed(uint64(h) >> hexCoordBits), signed(uint64(h))}
+}
+
+// position returns the center of the cell on the projection.
+func (h HexCell) position() complex128 {
+	a := h.axial()
+	res := h.Resolution()
+	return hexComplex([2]float64{float64(a[0]), float64(a[1])}) * complex(hexSpacing(res), 0) * hexOrientation(res)
+}
+
+// corners returns the six corners of the cell on the projection,
+// counterclockwise.
+func (h HexCell) corners() []complex128 {
+	corners := make([]complex128, 6)
+	center := h.position()
+	r := HexEdgeLength(h.Resolution())
+	for i := range corners {
+		corners[i] = center + complex(r, 0)*hexOrientation(h.Resolution())*complexAngle(rad(30+60*float64(i)))
+	}
+	return corners
+}
+
+// Clipped reports whether the cell crosses the antimeridian or reaches a
+// pole, and so is cut off at the edge of the projection.
+func (h HexCell) Clipped() bool {
+	x, y := hexBounds()
+	for _, c := range h.corners() {
+		if math.Abs(real(c)) > x || math.Abs(imag(c)) > y {
+			return true
+		}
+	}
+	return false
+}
+
+// Center returns the point at the center of the cell, or of its part on the
+// projection if it is clipped. A cell wholly off the projection has its
+// center moved to the nearest edge.
+func (h HexCell) Center() Point {
+	if !h.Clipped() {
+		return hexUnproject(h.position())
+	}
+	if area, centroid := polygonArea(hexClip(h.corners())); area > 0 {
+		return hexUnproject(centroid)
+	}
+	x, y := hexBounds()
+	z := h.position()
+	return hexUnproject(complex(math.Max(-x, math.Min(x, real(z))), math.Max(-y, math.Min(y, imag(z)))))
+}
+
+// Area returns the area of the cell in square kilometers: HexArea, less
+// whatever is clipped off.
+func (h HexCell) Area() float64 {
+	if !h.Clipped() {
+		return HexArea(h.Resolution())
+	}
+	area, _ := polygonArea(hexClip(h.corners()))
+	return area
+}
+
+// Boundary returns the corners of the cell, counterclockwise: six, or those
+// of its part on the projection if it is clipped, which lie on the
+// antimeridian or at a pole. On the sphere the edges between them are
+// straight on the projection, not great circles.
+func (h HexCell) Boundary() []Point {
+	corners := h.corners()
+	if h.Clipped() {
+		corners = hexClip(corners)
+	}
+	boundary := make([]Point, len(corners))
+	for i, c := range corners {
+		boundary[i] = hexUnproject(c)
+	}
+	return boundary
+}
+
+// complexAngle returns the unit complex number at angle radians.
+func complexAngle(angle float64) complex128 {
+	sin, cos := math.Sincos(angle)
+	return complex(cos, sin)
+}
+
+// WKT returns the boundary of the cell as a well-known text polygon.
+func (h HexCell) WKT() string {
+	boundary := h.Boundary()
+	if len(boundary) == 0 {
+		return "POLYGON EMPTY"
+	}
+	coords := make([]string, len(boundary)+1)
+	for i := range coords {
+		p := boundary[i%len(boundary)]
+		coords[i] = formatCoord(p.Lon) + " " + formatCoord(p.Lat)
+	}
+	return "POLYGON((" + strings.Join(coords, ", ") + "))"
+}
+
+// Parent returns the cell of res that h descends from. res must not be
+// finer than h's.
+func (h HexCell) Parent(res int) HexCell {
+	a := h.axial()
+	for r := h.Resolution(); r > res; r-- {
+		aperture := hexAperture(r - 1)
+		z := hexComplex([2]float64{float64(a[0]), float64(a[1])}) /
+			hexComplex([2]float64{float64(aperture[0]), float64(aperture[1])})
+		a = hexRound(z)
+	}
+	return newHexCell(res, a)
+}
+
+// Children returns the seven cells of the next resolution that descend from
+// h, the center child first. h must be coarser than HexMaxResolution.
+func (h HexCell) Children() [7]HexCell {
+	var children [7]HexCell
+	res := h.Resolution()
+	center := hexMultiply(h.axial(), hexAperture(res))
+	children[0] = newHexCell(res+1, center)
+	for i, u := range hexUnits {
+		children[i+1] = newHexCell(res+1, [2]int64{center[0] + u[0], center[1] + u[1]})
+	}
+	return children
+}
+
+// HexDistance returns the number of steps between neighbors from a to b,
+// which must have the same resolution.
+func HexDistance(a, b HexCell) int {
+	x, y := a.axial(), b.axial()
+	da, db := x[0]-y[0], x[1]-y[1]
+	return int((abs64(da) + abs64(db) + abs64(da+db)) / 2)
+}
+
+// abs64 returns the absolute value of x.
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Neighbors returns the six cells sharing an edge with h, counterclockwise
+// from east.
+func (h HexCell) Neighbors() [6]HexCell {
+	var neighbors [6]HexCell
+	a := h.axial()
+	for i, u := range hexUnits {
+		neighbors[i] = newHexCell(h.Resolution(), [2]int64{a[0] + u[0], a[1] + u[1]})
+	}
+	return neighbors
+}
+
+// KRing returns the cells within k steps of h, ring by ring outward, h
+// first.
+func (h HexCell) KRing(k int) []HexCell {
+	cells := []HexCell{h}
+	a := h.axial()
+	for ring := int64(1); ring <= int64(k); ring++ {
+		// Walk the ring from the cell ring steps to the southeast, then
+		// along each of the six sides.
+		cur := [2]int64{a[0] + hexUnits[4][0]*ring, a[1] + hexUnits[4][1]*ring}
+		for side := 0; side < 6; side++ {
+			for step := int64(0); step < ring; step++ {
+				cells = append(cells, newHexCell(h.Resolution(), cur))
+				cur[0] += hexUnits[side][0]
+				cur[1] += hexUnits[side][1]
+			}
+		}
+	}
+	return cells
+}
+
+// String returns the cell as hexadecimal, like an H3 index.
+func (h HexCell) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomPoint returns a point picked uniformly in longitude and latitude.
+func randomPoint(rnd *rand.Rand) Point {
+	return Point{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90}
+}
+
+func TestHexCellCenterRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 5000; n++ {
+		p := randomPoint(rnd)
+		res := n % (HexMaxResolution + 1)
+		h := HexCellFromPoint(p, res)
+		if h.Resolution() != res {
+			t.Fatalf("HexCellFromPoint(%v, %d) has resolution %d", p, res, h.Resolution())
+		}
+		if c := HexCellFromPoint(h.Center(), res); c != h {
+			t.Errorf("the center %v of %s, the cell of %v, is in %s", h.Center(), h, p, c)
+		}
+	}
+}
+
+func TestHexCellEdges(t *testing.T) {
+	points := []Point{
+		{180, 0}, {-180, 0}, {179.9999, 12.5}, {-179.9999, -40},
+		{0, 90}, {0, -90}, {45, 89.9999}, {-120, -89.9999},
+		{180, 90}, {-180, -90},
+	}
+	for _, p := range points {
+		for res := 0; res <= HexMaxResolution; res++ {
+			h := HexCellFromPoint(p, res)
+			if c := HexCellFromPoint(h.Center(), res); c != h {
+				t.Errorf("the center %v of %s, the cell of %v, is in %s", h.Center(), h, p, c)
+			}
+			area := h.Area()
+			if area <= 0 || area > HexArea(res)*(1+1e-9) {
+				t.Errorf("%s, the cell of %v, has area %g of %g", h, p, area, HexArea(res))
+			}
+			if h.Clipped() != (area < HexArea(res)*(1-1e-9)) {
+				t.Errorf("%s, the cell of %v, has area %g of %g, but Clipped is %v", h, p, area, HexArea(res), h.Clipped())
+			}
+			minLon, maxLon := math.Inf(1), math.Inf(-1)
+			for _, b := range h.Boundary() {
+				if b.Lon < -180-1e-9 || b.Lon > 180+1e-9 || b.Lat < -90 || b.Lat > 90 {
+					t.Fatalf("%s, the cell of %v, has corner %v off the globe", h, p, b)
+				}
+				minLon, maxLon = math.Min(minLon, b.Lon), math.Max(maxLon, b.Lon)
+			}
+			// The cells of resolution 0 are 20 degrees or so across.
+			if res > 0 && maxLon-minLon > 90 {
+				t.Errorf("%s, the cell of %v, spans longitudes %g to %g", h, p, minLon, maxLon)
+			}
+		}
+	}
+}
+
+func TestHexCellDensityClipped(t *testing.T) {
+	h := HexCellFromPoint(Point{180, 0}, 5)
+	if !h.Clipped() {
+		t.Fatalf("%s on the antimeridian is not clipped", h)
+	}
+	c := HexCount{Cell: h, Count: 10}
+	if want := 10 / h.Area(); c.Density() != want || c.Density() <= 10/HexArea(5) {
+		t.Errorf("Density() = %g, want %g over the clipped area", c.Density(), want)
+	}
+}
+
+func TestHexCellParentOfChildren(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for n := 0; n < 2000; n++ {
+		res := n % HexMaxResolution
+		h := HexCellFromPoint(randomPoint(rnd), res)
+		for _, c := range h.Children() {
+			if c.Resolution() != res+1 {
+				t.Fatalf("child %s of %s has resolution %d", c, h, c.Resolution())
+			}
+			if parent := c.Parent(res); parent != h {
+				t.Errorf("the parent of %s, a child of %s, is %s", c, h, parent)
+			}
+		}
+		if res > 0 {
+			if parent := h.Parent(0); parent.Resolution() != 0 {
+				t.Errorf("the resolution 0 parent of %s has resolution %d", h, parent.Resolution())
+			}
+		}
+	}
+}
+
+func TestHexCellKRing(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	for n := 0; n < 200; n++ {
+		h := HexCellFromPoint(randomPoint(rnd), n%(HexMaxResolution+1))
+		for _, c := range h.Neighbors() {
+			if d := HexDistance(h, c); d != 1 {
+				t.Fatalf("neighbor %s of %s is %d steps away", c, h, d)
+			}
+		}
+		for k := 0; k <= 5; k++ {
+			ring := h.KRing(k)
+			if len(ring) != 1+3*k*(k+1) {
+				t.Fatalf("KRing(%d) of %s has %d cells, want %d", k, h, len(ring), 1+3*k*(k+1))
+			}
+			seen := make(map[HexCell]bool)
+			for _, c := range ring {
+				if seen[c] {
+					t.Fatalf("KRing(%d) of %s has %s twice", k, h, c)
+				}
+				seen[c] = true
+				if d := HexDistance(h, c); d > k {
+					t.Fatalf("KRing(%d) of %s has %s, %d steps away", k, h, c, d)
+				}
+			}
+		}
+	}
+}
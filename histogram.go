@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramSubBits sets the precision of a Histogram: every power of two
+// range is split into 2^histogramSubBits linear buckets, which bounds the
+// relative error of a recorded value to under 1%.
+const histogramSubBits = 7
+
+const histogramSubBuckets = 1 << histogramSubBits
+
+// Histogram is a log-linear latency histogram in the style of HdrHistogram.
+// It records durations with bounded relative error in constant memory, and
+// can compensate for coordinated omission.
+type Histogram struct {
+	counts [64 * histogramSubBuckets]int64
+	count  int64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+// NewHistogram creates an empty Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{min: math.MaxInt64}
+}
+
+// bucketOf returns the bucket index for v >= 0.
+func bucketOf(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// The position of the highest bit selects the power of two range, and
+	// the next histogramSubBits bits select the linear bucket within it.
+	shift := bits.Len64(uint64(v)) - histogramSubBits - 1
+	return (shift+1)*histogramSubBuckets + int(v>>uint(shift)) - histogramSubBuckets
+}
+
+// bucketValue returns the highest value that falls into bucket i.
+func bucketValue(i int) int64 {
+	if i < histogramSubBuckets {
+		return int64(i)
+	}
+	shift := i/histogramSubBuckets - 1
+	sub := int64(i%histogramSubBuckets + histogramSubBuckets)
+	return (sub+1)<<uint(shift) - 1
+}
+
+// Record adds a single duration to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucketOf(v)]++
+	h.count++
+	h.sum += float64(v)
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// RecordCorrected records d, and if d is longer than the expected interval
+// between requests, also records the requests that a closed-loop client
+// would have issued during the stall had it not been waiting. This corrects
+// for coordinated omission.
+func (h *Histogram) RecordCorrected(d, expectedInterval time.Duration) {
+	h.Record(d)
+	if expectedInterval <= 0 {
+		return
+	}
+	for missing := d - expectedInterval; missing >= expectedInterval; missing -= expectedInterval {
+		h.Record(missing)
+	}
+}
+
+// Merge adds all the values recorded in other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the number of values recorded.
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Min returns the smallest value recorded.
+func (h *Histogram) Min() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.min)
+}
+
+// Max returns the largest value recorded.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Mean returns the average of the values recorded.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// Percentile returns the value below which p percent of the recorded values
+// fall, to within the histogram's precision.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p / 100 * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := bucketValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
@@ -0,0 +1,688 @@
+package main
+
+import "math"
+
+// Karney solves the inverse geodesic problem from p to q with Karney's
+// algorithm, which converges everywhere, antipodal points included, and is
+// accurate to about 15 nanometers. It is a port of the inverse solution in
+// GeographicLib, keeping the distance and azimuths. Only oblate ellipsoids,
+// with F >= 0, are supported.
+//
+// See C. F. F. Karney, Algorithms for geodesics, J. Geodesy 87, 43-55 (2013)
+// and https://geographiclib.sourceforge.io
+func (e Ellipsoid) Karney(p, q Point) Geodesic {
+	k := newKarney(e)
+	return k.inverse(p.Lat, p.Lon, q.Lat, q.Lon)
+}
+
+// Orders of the series expansions, as in GeographicLib's default build.
+const (
+	karneyOrder = 6
+	nA1         = karneyOrder
+	nC1         = karneyOrder
+	nA2         = karneyOrder
+	nC2         = karneyOrder
+	nA3         = karneyOrder
+	nA3x        = nA3
+	nC3         = karneyOrder
+	nC3x        = (nC3 * (nC3 - 1)) / 2
+)
+
+// Tolerances and iteration limits of the inverse solution.
+var (
+	karneyTiny    = math.Sqrt(math.SmallestNonzeroFloat64 * (1 << 52)) // sqrt of the smallest normal float64
+	karneyTol0    = math.Nextafter(1, 2) - 1                           // machine epsilon
+	karneyTol1    = 200 * karneyTol0
+	karneyTol2    = math.Sqrt(karneyTol0)
+	karneyTolb    = karneyTol0 * karneyTol2
+	karneyXthresh = 1000 * karneyTol2
+	karneyMaxit1  = 20
+	karneyMaxit2  = karneyMaxit1 + 53 + 10
+)
+
+// karney holds the constants of an ellipsoid used by the inverse solution.
+type karney struct {
+	a, f, f1, e2, ep2, n, b float64
+	etol2                   float64
+	a3x                     [nA3x]float64
+	c3x                     [nC3x]float64
+}
+
+func newKarney(e Ellipsoid) *karney {
+	k := &karney{a: e.A, f: e.F}
+	k.f1 = 1 - k.f
+	k.e2 = k.f * (2 - k.f)
+	k.ep2 = k.e2 / (k.f1 * k.f1)
+	k.n = k.f / (2 - k.f)
+	k.b = k.a * k.f1
+	k.etol2 = 0.1 * karneyTol2 / math.Sqrt(math.Max(0.001, math.Abs(k.f))*math.Min(1, 1-k.f/2)/2)
+	k.a3coeff()
+	k.c3coeff()
+	return k
+}
+
+// polyval evaluates the polynomial of degree n with coefficients p[s:s+n+1],
+// highest degree first, at x.
+func polyval(n int, p []float64, s int, x float64) float64 {
+	if n < 0 {
+		return 0
+	}
+	y := p[s]
+	for ; n > 0; n-- {
+		s++
+		y = y*x + p[s]
+	}
+	return y
+}
+
+// angSum returns the sum of u and v, and the rounding error of the sum.
+func angSum(u, v float64) (float64, float64) {
+	s := u + v
+	up := s - v
+	vpp := s - up
+	up -= u
+	vpp -= v
+	return s, -(up + vpp)
+}
+
+// angNormalize reduces an angle in degrees to (-180, 180].
+func angNormalize(x float64) float64 {
+	y := math.Remainder(x, 360)
+	if y == -180 {
+		return 180
+	}
+	return y
+}
+
+// angDiff returns y - x in degrees reduced to [-180, 180], and its rounding
+// error.
+func angDiff(x, y float64) (float64, float64) {
+	d, t := angSum(angNormalize(-x), angNormalize(y))
+	d = angNormalize(d)
+	if d == 180 && t > 0 {
+		d = -180
+	}
+	return angSum(d, t)
+}
+
+// angRound rounds tiny angles so that small differences are exact.
+func angRound(x float64) float64 {
+	const z = 1.0 / 16
+	if x == 0 {
+		return 0
+	}
+	y := math.Abs(x)
+	if y < z {
+		y = z - (z - y)
+	}
+	if x < 0 {
+		return -y
+	}
+	return y
+}
+
+// sincosd returns the sine and cosine of x in degrees, exactly for
+// multiples of 90.
+func sincosd(x float64) (float64, float64) {
+	r := math.Mod(x, 360)
+	q := 0
+	if !math.IsNaN(r) {
+		q = int(math.RoundToEven(r / 90))
+	}
+	r = rad(r - 90*float64(q))
+	s, c := math.Sincos(r)
+	switch ((q % 4) + 4) % 4 {
+	case 1:
+		s, c = c, -s
+	case 2:
+		s, c = -s, -c
+	case 3:
+		s, c = -c, s
+	}
+	if x != 0 {
+		s, c = s+0, c+0
+	}
+	return s, c
+}
+
+// atan2d returns atan2(y, x) in degrees, exactly for multiples of 45.
+func atan2d(y, x float64) float64 {
+	q := 0
+	if math.Abs(y) > math.Abs(x) {
+		q = 2
+		x, y = y, x
+	}
+	if x < 0 {
+		q++
+		x = -x
+	}
+	ang := deg(math.Atan2(y, x))
+	switch q {
+	case 1:
+		if y >= 0 {
+			ang = 180 - ang
+		} else {
+			ang = -180 - ang
+		}
+	case 2:
+		ang = 90 - ang
+	case 3:
+		ang = -90 + ang
+	}
+	return ang
+}
+
+// norm scales x and y to a unit vector.
+func norm(x, y float64) (float64, float64) {
+	r := math.Hypot(x, y)
+	return x / r, y / r
+}
+
+// sinCosSeries evaluates the sum of c[l] sin(2lx), or c[l] cos((2l-1)x)
+// if sinp is false, by Clenshaw summation.
+func sinCosSeries(sinp bool, sinx, cosx float64, c []float64) float64 {
+	k := len(c)
+	n := k
+	if sinp {
+		n--
+	}
+	ar := 2 * (cosx - sinx) * (cosx + sinx)
+	var y0, y1 float64
+	if n&1 != 0 {
+		k--
+		y0 = c[k]
+	}
+	for n /= 2; n > 0; n-- {
+		k--
+		y1 = ar*y0 - y1 + c[k]
+		k--
+		y0 = ar*y1 - y0 + c[k]
+	}
+	if sinp {
+		return 2 * sinx * cosx * y0
+	}
+	return cosx * (y0 - y1)
+}
+
+// a1m1f returns A1 - 1, the scale of the distance integral.
+func a1m1f(eps float64) float64 {
+	coeff := []float64{1, 4, 64, 0, 256}
+	m := nA1 / 2
+	t := polyval(m, coeff, 0, eps*eps) / coeff[m+1]
+	return (t + eps) / (1 - eps)
+}
+
+// c1f fills c[1:] with the coefficients C1 of the distance integral.
+func c1f(eps float64, c []float64) {
+	coeff := []float64{
+		-1, 6, -16, 32,
+		-9, 64, -128, 2048,
+		9, -16, 768,
+		3, -5, 512,
+		-7, 1280,
+		-7, 2048,
+	}
+	seriesCoeffs(nC1, coeff, eps, c)
+}
+
+// a2m1f returns A2 - 1, the scale of the reduced length integral.
+func a2m1f(eps float64) float64 {
+	coeff := []float64{-11, -28, -192, 0, 256}
+	m := nA2 / 2
+	t := polyval(m, coeff, 0, eps*eps) / coeff[m+1]
+	return (t - eps) / (1 + eps)
+}
+
+// c2f fills c[1:] with the coefficients C2 of the reduced length integral.
+func c2f(eps float64, c []float64) {
+	coeff := []float64{
+		1, 2, 16, 32,
+		35, 64, 384, 2048,
+		15, 80, 768,
+		7, 35, 512,
+		63, 1280,
+		77, 2048,
+	}
+	seriesCoeffs(nC2, coeff, eps, c)
+}
+
+// seriesCoeffs evaluates the n coefficients of a series in eps, given as
+// polynomials in eps squared, into c[1:].
+func seriesCoeffs(n int, coeff []float64, eps float64, c []float64) {
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= n; l++ {
+		m := (n - l) / 2
+		c[l] = d * polyval(m, coeff, o, eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+func (k *karney) a3coeff() {
+	coeff := []float64{
+		-3, 128,
+		-2, -3, 64,
+		-1, -3, -1, 16,
+		3, -1, -2, 8,
+		1, -1, 2,
+		1, 1,
+	}
+	o, i := 0, 0
+	for j := nA3 - 1; j >= 0; j-- {
+		m := nA3 - j - 1
+		if j < m {
+			m = j
+		}
+		k.a3x[i] = polyval(m, coeff, o, k.n) / coeff[o+m+1]
+		i++
+		o += m + 2
+	}
+}
+
+func (k *karney) c3coeff() {
+	coeff := []float64{
+		3, 128,
+		2, 5, 128,
+		-1, 3, 3, 64,
+		-1, 0, 1, 8,
+		-1, 1, 4,
+		5, 256,
+		1, 3, 128,
+		-3, -2, 3, 64,
+		1, -3, 2, 32,
+		7, 512,
+		-10, 9, 384,
+		5, -9, 5, 192,
+		7, 512,
+		-14, 7, 512,
+		21, 2560,
+	}
+	o, i := 0, 0
+	for l := 1; l < nC3; l++ {
+		for j := nC3 - 1; j >= l; j-- {
+			m := nC3 - j - 1
+			if j < m {
+				m = j
+			}
+			k.c3x[i] = polyval(m, coeff, o, k.n) / coeff[o+m+1]
+			i++
+			o += m + 2
+		}
+	}
+}
+
+func (k *karney) a3f(eps float64) float64 {
+	return polyval(nA3-1, k.a3x[:], 0, eps)
+}
+
+func (k *karney) c3f(eps float64, c []float64) {
+	mult := 1.0
+	o := 0
+	for l := 1; l < nC3; l++ {
+		m := nC3 - l - 1
+		mult *= eps
+		c[l] = mult * polyval(m, k.c3x[:], o, eps)
+		o += m + 1
+	}
+}
+
+// lengths returns the distance and reduced length, scaled by b, of a
+// geodesic between the points at sigma1 and sigma2.
+func (k *karney) lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2 float64, c1a, c2a []float64) (s12b, m12b float64) {
+	a1 := a1m1f(eps)
+	c1f(eps, c1a)
+	a2 := a2m1f(eps)
+	c2f(eps, c2a)
+	m0x := a1 - a2
+	a1++
+	a2++
+	b1 := sinCosSeries(true, ssig2, csig2, c1a) - sinCosSeries(true, ssig1, csig1, c1a)
+	s12b = a1 * (sig12 + b1)
+	b2 := sinCosSeries(true, ssig2, csig2, c2a) - sinCosSeries(true, ssig1, csig1, c2a)
+	j12 := m0x*sig12 + (a1*b1 - a2*b2)
+	m12b = dn2*(csig1*ssig2) - dn1*(ssig1*csig2) - csig1*csig2*j12
+	return s12b, m12b
+}
+
+// astroid solves k^4 + 2k^3 - (x^2 + y^2 - 1)k^2 - 2y^2k - y^2 = 0 for its
+// positive root.
+func astroid(x, y float64) float64 {
+	p := x * x
+	q := y * y
+	r := (p + q - 1) / 6
+	if q == 0 && r <= 0 {
+		return 0
+	}
+	S := p * q / 4
+	r2 := r * r
+	r3 := r * r2
+	disc := S * (S + 2*r3)
+	u := r
+	if disc >= 0 {
+		T3 := S + r3
+		if T3 < 0 {
+			T3 -= math.Sqrt(disc)
+		} else {
+			T3 += math.Sqrt(disc)
+		}
+		T := math.Cbrt(T3)
+		u += T
+		if T != 0 {
+			u += r2 / T
+		}
+	} else {
+		ang := math.Atan2(math.Sqrt(-disc), -(S + r3))
+		u += 2 * r * math.Cos(ang/3)
+	}
+	v := math.Sqrt(u*u + q)
+	var uv float64
+	if u < 0 {
+		uv = q / (v - u)
+	} else {
+		uv = u + v
+	}
+	w := (uv - q) / (2 * v)
+	return uv / (math.Sqrt(uv+w*w) + w)
+}
+
+// inverseStart returns a starting guess for the azimuth at the first point,
+// or the solution itself, with sig12 >= 0, for short lines.
+func (k *karney) inverseStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2, lam12, slam12, clam12 float64) (sig12, salp1, calp1, salp2, calp2, dnm float64) {
+	sig12 = -1
+	sbet12 := sbet2*cbet1 - cbet2*sbet1
+	cbet12 := cbet2*cbet1 + sbet2*sbet1
+	sbet12a := sbet2*cbet1 + cbet2*sbet1
+	shortline := cbet12 >= 0 && sbet12 < 0.5 && cbet2*lam12 < 0.5
+	var somg12, comg12 float64
+	if shortline {
+		sbetm2 := (sbet1 + sbet2) * (sbet1 + sbet2)
+		sbetm2 /= sbetm2 + (cbet1+cbet2)*(cbet1+cbet2)
+		dnm = math.Sqrt(1 + k.ep2*sbetm2)
+		omg12 := lam12 / (k.f1 * dnm)
+		somg12, comg12 = math.Sincos(omg12)
+	} else {
+		somg12, comg12 = slam12, clam12
+	}
+
+	salp1 = cbet2 * somg12
+	if comg12 >= 0 {
+		calp1 = sbet12 + cbet2*sbet1*somg12*somg12/(1+comg12)
+	} else {
+		calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+	}
+	ssig12 := math.Hypot(salp1, calp1)
+	csig12 := sbet1*sbet2 + cbet1*cbet2*comg12
+
+	switch {
+	case shortline && ssig12 < k.etol2:
+		// Really short lines.
+		salp2 = cbet1 * somg12
+		if comg12 >= 0 {
+			calp2 = sbet12 - cbet1*sbet2*(somg12*somg12/(1+comg12))
+		} else {
+			calp2 = sbet12 - cbet1*sbet2*(1-comg12)
+		}
+		salp2, calp2 = norm(salp2, calp2)
+		sig12 = math.Atan2(ssig12, csig12)
+	case math.Abs(k.n) >= 0.1 || csig12 >= 0 || ssig12 >= 6*math.Abs(k.n)*math.Pi*cbet1*cbet1:
+		// Nothing to do: the zeroth order guess is good enough.
+	default:
+		// Nearly antipodal points: scale to the astroid problem.
+		lam12x := math.Atan2(-slam12, -clam12)
+		k2 := sbet1 * sbet1 * k.ep2
+		eps := k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+		lamscale := k.f * cbet1 * k.a3f(eps) * math.Pi
+		betscale := lamscale * cbet1
+		x := lam12x / lamscale
+		y := sbet12a / betscale
+		if y > -karneyTol1 && x > -1-karneyXthresh {
+			salp1 = math.Min(1, -x)
+			calp1 = -math.Sqrt(1 - salp1*salp1)
+		} else {
+			kk := astroid(x, y)
+			omg12a := lamscale * (-x * kk / (1 + kk))
+			somg12, comg12 = math.Sincos(omg12a)
+			comg12 = -comg12
+			salp1 = cbet2 * somg12
+			calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+		}
+	}
+	if !(salp1 <= 0) {
+		salp1, calp1 = norm(salp1, calp1)
+	} else {
+		salp1, calp1 = 1, 0
+	}
+	return
+}
+
+// lambda12 returns the longitude difference reached by the geodesic leaving
+// the first point at azimuth alp1, less the one wanted, and, if diffp is
+// set, its derivative with respect to alp1.
+func (k *karney) lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam120, clam120 float64, diffp bool, c1a, c2a, c3a []float64) (lam12, salp2, calp2, sig12, ssig1, csig1, ssig2, csig2, eps, dlam12 float64) {
+	if sbet1 == 0 && calp1 == 0 {
+		// Break the degeneracy of equatorial lines.
+		calp1 = -karneyTiny
+	}
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	ssig1 = sbet1
+	somg1 := salp0 * sbet1
+	csig1 = calp1 * cbet1
+	comg1 := csig1
+	ssig1, csig1 = norm(ssig1, csig1)
+
+	if cbet2 != cbet1 {
+		salp2 = salp0 / cbet2
+	} else {
+		salp2 = salp1
+	}
+	if cbet2 != cbet1 || math.Abs(sbet2) != -sbet1 {
+		var d float64
+		if cbet1 < -sbet1 {
+			d = (cbet2 - cbet1) * (cbet1 + cbet2)
+		} else {
+			d = (sbet1 - sbet2) * (sbet1 + sbet2)
+		}
+		calp2 = math.Sqrt(calp1*cbet1*calp1*cbet1+d) / cbet2
+	} else {
+		calp2 = math.Abs(calp1)
+	}
+
+	ssig2 = sbet2
+	somg2 := salp0 * sbet2
+	csig2 = calp2 * cbet2
+	comg2 := csig2
+	ssig2, csig2 = norm(ssig2, csig2)
+
+	sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+	somg12 := math.Max(0, comg1*somg2-somg1*comg2)
+	comg12 := comg1*comg2 + somg1*somg2
+	eta := math.Atan2(somg12*clam120-comg12*slam120, comg12*clam120+somg12*slam120)
+
+	k2 := calp0 * calp0 * k.ep2
+	eps = k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+	k.c3f(eps, c3a)
+	b312 := sinCosSeries(true, ssig2, csig2, c3a) - sinCosSeries(true, ssig1, csig1, c3a)
+	domg12 := -k.f * k.a3f(eps) * salp0 * (sig12 + b312)
+	lam12 = eta + domg12
+
+	if diffp {
+		if calp2 == 0 {
+			dlam12 = -2 * k.f1 * dn1 / sbet1
+		} else {
+			_, m12b := k.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+			dlam12 = m12b * k.f1 / (calp2 * cbet2)
+		}
+	} else {
+		dlam12 = math.NaN()
+	}
+	return
+}
+
+// inverse solves the inverse problem between two points given in degrees.
+func (k *karney) inverse(lat1, lon1, lat2, lon2 float64) Geodesic {
+	// Arrange the points so that lat1 <= 0, lat1 <= -|lat2| and
+	// 0 <= lon12 <= 180, and undo it at the end.
+	lon12, lon12s := angDiff(lon1, lon2)
+	lonsign := 1.0
+	if lon12 < 0 {
+		lonsign = -1
+	}
+	lon12 = lonsign * angRound(lon12)
+	lon12s = angRound((180 - lon12) - lonsign*lon12s)
+	lam12 := rad(lon12)
+	var slam12, clam12 float64
+	if lon12 > 90 {
+		slam12, clam12 = sincosd(lon12s)
+		clam12 = -clam12
+	} else {
+		slam12, clam12 = sincosd(lon12)
+	}
+
+	lat1 = angRound(latFix(lat1))
+	lat2 = angRound(latFix(lat2))
+	swapp := 1.0
+	if math.Abs(lat1) < math.Abs(lat2) {
+		swapp = -1
+		lonsign *= -1
+		lat1, lat2 = lat2, lat1
+	}
+	latsign := -1.0
+	if lat1 < 0 {
+		latsign = 1
+	}
+	lat1 *= latsign
+	lat2 *= latsign
+
+	sbet1, cbet1 := sincosd(lat1)
+	sbet1 *= k.f1
+	sbet1, cbet1 = norm(sbet1, cbet1)
+	cbet1 = math.Max(karneyTiny, cbet1)
+	sbet2, cbet2 := sincosd(lat2)
+	sbet2 *= k.f1
+	sbet2, cbet2 = norm(sbet2, cbet2)
+	cbet2 = math.Max(karneyTiny, cbet2)
+
+	if cbet1 < -sbet1 {
+		if cbet2 == cbet1 {
+			sbet2 = math.Copysign(sbet1, sbet2)
+		}
+	} else if math.Abs(sbet2) == -sbet1 {
+		cbet2 = cbet1
+	}
+
+	dn1 := math.Sqrt(1 + k.ep2*sbet1*sbet1)
+	dn2 := math.Sqrt(1 + k.ep2*sbet2*sbet2)
+
+	c1a := make([]float64, nC1+1)
+	c2a := make([]float64, nC2+1)
+	c3a := make([]float64, nC3)
+
+	var s12x, sig12, salp1, calp1, salp2, calp2 float64
+	meridian := lat1 == -90 || slam12 == 0
+	if meridian {
+		// The geodesic runs along a meridian.
+		calp1, salp1 = clam12, slam12
+		calp2, salp2 = 1, 0
+		ssig1, csig1 := sbet1, calp1*cbet1
+		ssig2, csig2 := sbet2, calp2*cbet2
+		sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+		var m12x float64
+		s12x, m12x = k.lengths(k.n, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+		if sig12 < 1 || m12x >= 0 {
+			if sig12 < 3*karneyTiny || (sig12 < karneyTol0 && (s12x < 0 || m12x < 0)) {
+				sig12, s12x = 0, 0
+			}
+			s12x *= k.b
+		} else {
+			// The meridian is not the shortest path past the pole.
+			meridian = false
+		}
+	}
+
+	switch {
+	case !meridian && sbet1 == 0 && (k.f <= 0 || lon12s >= k.f*180):
+		// The geodesic runs along the equator.
+		calp1, calp2 = 0, 0
+		salp1, salp2 = 1, 1
+		s12x = k.a * lam12
+	case !meridian:
+		var dnm float64
+		sig12, salp1, calp1, salp2, calp2, dnm = k.inverseStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2, lam12, slam12, clam12)
+		if sig12 >= 0 {
+			// A short line, solved by inverseStart.
+			s12x = sig12 * k.b * dnm
+		} else {
+			// Newton's method on alp1, falling back to bisection.
+			var ssig1, csig1, ssig2, csig2, eps float64
+			tripn, tripb := false, false
+			salp1a, calp1a := karneyTiny, 1.0
+			salp1b, calp1b := karneyTiny, -1.0
+			for numit := 0; numit < karneyMaxit2; {
+				var v, dv float64
+				v, salp2, calp2, sig12, ssig1, csig1, ssig2, csig2, eps, dv = k.lambda12(
+					sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam12, clam12,
+					numit < karneyMaxit1, c1a, c2a, c3a)
+				tol := karneyTol0
+				if tripn {
+					tol *= 8
+				}
+				if tripb || !(math.Abs(v) >= tol) {
+					break
+				}
+				if v > 0 && (numit < karneyMaxit1 || calp1/salp1 > calp1b/salp1b) {
+					salp1b, calp1b = salp1, calp1
+				} else if v < 0 && (numit < karneyMaxit1 || calp1/salp1 < calp1a/salp1a) {
+					salp1a, calp1a = salp1, calp1
+				}
+				numit++
+				if numit < karneyMaxit1 && dv > 0 {
+					dalp1 := -v / dv
+					sdalp1, cdalp1 := math.Sincos(dalp1)
+					nsalp1 := salp1*cdalp1 + calp1*sdalp1
+					if nsalp1 > 0 && math.Abs(dalp1) < math.Pi {
+						calp1 = calp1*cdalp1 - salp1*sdalp1
+						salp1 = nsalp1
+						salp1, calp1 = norm(salp1, calp1)
+						tripn = math.Abs(v) <= 16*karneyTol0
+						continue
+					}
+				}
+				salp1 = (salp1a + salp1b) / 2
+				calp1 = (calp1a + calp1b) / 2
+				salp1, calp1 = norm(salp1, calp1)
+				tripn = false
+				tripb = math.Abs(salp1a-salp1)+(calp1a-calp1) < karneyTolb ||
+					math.Abs(salp1-salp1b)+(calp1-calp1b) < karneyTolb
+			}
+			s12x, _ = k.lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+			s12x *= k.b
+		}
+	}
+
+	if swapp < 0 {
+		salp1, salp2 = salp2, salp1
+		calp1, calp2 = calp2, calp1
+	}
+	salp1 *= swapp * lonsign
+	calp1 *= swapp * latsign
+	salp2 *= swapp * lonsign
+	calp2 *= swapp * latsign
+
+	return Geodesic{
+		Distance: s12x + 0,
+		Azimuth1: atan2d(salp1, calp1),
+		Azimuth2: atan2d(salp2, calp2),
+	}
+}
+
+// latFix returns NaN for latitudes beyond the poles.
+func latFix(x float64) float64 {
+	if math.Abs(x) > 90 {
+		return math.NaN()
+	}
+	return x
+}
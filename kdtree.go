@@ -0,0 +1,206 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// KDTree is a k-d tree of points on their unit vectors in three dimensions,
+// rather than on longitude and latitude, so that it has no edges at the
+// antimeridian or the poles. The straight line, or chord, between two unit
+// vectors grows with the great circle distance between them, so chords order
+// and bound the points as Distance2 would, but far more cheaply.
+//
+// The tree is implicit: the points from lo to hi are a node, split at the
+// middle point on the axis stored for it, with the points before it no
+// greater on that axis and the points after it no less.
+type KDTree struct {
+	points  []IndexedPoint
+	vectors []vector
+	axes    []uint8
+}
+
+// coord returns the coordinate of v on axis 0, 1 or 2.
+func (v vector) coord(axis uint8) float64 {
+	switch axis {
+	case 0:
+		return v.x
+	case 1:
+		return v.y
+	}
+	return v.z
+}
+
+// chord2 returns the square of the straight line distance from v to w.
+func chord2(v, w vector) float64 {
+	dx, dy, dz := v.x-w.x, v.y-w.y, v.z-w.z
+	return dx*dx + dy*dy + dz*dz
+}
+
+// chordLength returns the chord between unit vectors radius kilometers apart
+// on the sphere of radius Rk.
+func chordLength(radius float64) float64 {
+	return 2 * math.Sin(math.Min(radius/Rk, math.Pi)/2)
+}
+
+// kdRange sorts the points of a node of t by axis.
+type kdRange struct {
+	t      *KDTree
+	lo, hi int
+	axis   uint8
+}
+
+func (r kdRange) Len() int { return r.hi - r.lo }
+func (r kdRange) Less(i, j int) bool {
+	return r.t.vectors[r.lo+i].coord(r.axis) < r.t.vectors[r.lo+j].coord(r.axis)
+}
+func (r kdRange) Swap(i, j int) {
+	i, j = r.lo+i, r.lo+j
+	r.t.points[i], r.t.points[j] = r.t.points[j], r.t.points[i]
+	r.t.vectors[i], r.t.vectors[j] = r.t.vectors[j], r.t.vectors[i]
+}
+
+// NewKDTree builds a KDTree of points.
+func NewKDTree(points []IndexedPoint) *KDTree {
+	t := &KDTree{
+		points:  append([]IndexedPoint(nil), points...),
+		vectors: make([]vector, len(points)),
+		axes:    make([]uint8, len(points)),
+	}
+	for i, p := range t.points {
+		t.vectors[i] = pointVector(p.Point)
+	}
+	t.build(0, len(points))
+	return t
+}
+
+// build arranges the points from lo to hi into a node, split on the axis
+// along which they are most spread out.
+func (t *KDTree) build(lo, hi int) {
+	if hi-lo < 2 {
+		return
+	}
+	low := t.vectors[lo]
+	high := low
+	for _, v := range t.vectors[lo+1 : hi] {
+		low = vector{math.Min(low.x, v.x), math.Min(low.y, v.y), math.Min(low.z, v.z)}
+		high = vector{math.Max(high.x, v.x), math.Max(high.y, v.y), math.Max(high.z, v.z)}
+	}
+	var axis uint8
+	for a := uint8(1); a < 3; a++ {
+		if high.coord(a)-low.coord(a) > high.coord(axis)-low.coord(axis) {
+			axis = a
+		}
+	}
+	mid := (lo + hi) / 2
+	sort.Sort(kdRange{t, lo, hi, axis})
+	t.axes[mid] = axis
+	t.build(lo, mid)
+	t.build(mid+1, hi)
+}
+
+// Len returns the number of points in the tree.
+func (t *KDTree) Len() int {
+	return len(t.points)
+}
+
+// Within returns the points within radius kilometers of p. Nodes beyond the
+// chord of radius are skipped, and the points within it are measured with
+// Distance2.
+func (t *KDTree) Within(p Point, radius float64) []Match {
+	var matches []Match
+	v := pointVector(p)
+	// The chord is widened a little, so that rounding never loses a point
+	// that Distance2 puts within radius.
+	chord := chordLength(radius) + 1e-12
+	t.within(0, len(t.points), v, chord, func(i int) {
+		q := t.points[i]
+		if d := Distance2(p.Lon, p.Lat, q.Lon, q.Lat); d <= radius {
+			matches = append(matches, indexMatch(q, d))
+		}
+	})
+	return matches
+}
+
+// within calls visit for the points from lo to hi within chord of v.
+func (t *KDTree) within(lo, hi int, v vector, chord float64, visit func(i int)) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	if chord2(v, t.vectors[mid]) <= chord*chord {
+		visit(mid)
+	}
+	axis := t.axes[mid]
+	d := v.coord(axis) - t.vectors[mid].coord(axis)
+	if d <= chord {
+		t.within(lo, mid, v, chord, visit)
+	}
+	if d >= -chord {
+		t.within(mid+1, hi, v, chord, visit)
+	}
+}
+
+// kdCandidate is a point found by a nearest neighbor search, and the square
+// of its chord from the point searched for.
+type kdCandidate struct {
+	index  int
+	chord2 float64
+}
+
+// kdHeap is a priority queue of candidates, farthest first, holding the
+// nearest found so far.
+type kdHeap []kdCandidate
+
+func (h kdHeap) Len() int            { return len(h) }
+func (h kdHeap) Less(i, j int) bool  { return h[i].chord2 > h[j].chord2 }
+func (h kdHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *kdHeap) Push(x interface{}) { *h = append(*h, x.(kdCandidate)) }
+func (h *kdHeap) Pop() (last interface{}) {
+	last, *h = (*h)[len(*h)-1], (*h)[:len(*h)-1]
+	return last
+}
+
+// Nearest returns the k points nearest to p. The side of each node that p
+// falls on is searched first, and the other side only if it could hold a
+// point nearer than the k found so far.
+func (t *KDTree) Nearest(p Point, k int) []Match {
+	if k <= 0 {
+		return nil
+	}
+	found := &kdHeap{}
+	t.nearest(0, len(t.points), pointVector(p), k, found)
+
+	matches := make([]Match, found.Len())
+	for i := len(matches) - 1; i >= 0; i-- {
+		q := t.points[heap.Pop(found).(kdCandidate).index]
+		matches[i] = indexMatch(q, Distance2(p.Lon, p.Lat, q.Lon, q.Lat))
+	}
+	return matches
+}
+
+// nearest adds the points from lo to hi to found, keeping the k nearest to v.
+func (t *KDTree) nearest(lo, hi int, v vector, k int, found *kdHeap) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	if c := chord2(v, t.vectors[mid]); found.Len() < k {
+		heap.Push(found, kdCandidate{mid, c})
+	} else if c < (*found)[0].chord2 {
+		(*found)[0] = kdCandidate{mid, c}
+		heap.Fix(found, 0)
+	}
+
+	axis := t.axes[mid]
+	d := v.coord(axis) - t.vectors[mid].coord(axis)
+	near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+	if d > 0 {
+		near, far = far, near
+	}
+	t.nearest(near[0], near[1], v, k, found)
+	if found.Len() < k || d*d < (*found)[0].chord2 {
+		t.nearest(far[0], far[1], v, k, found)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// MaxInsertBatch is the largest batch a multi-row INSERT can hold, given
+// MySQL's limit of 65535 placeholders per statement and the 19 placeholders
+// of an InsertValues row with every optional column.
+const MaxInsertBatch = 65535 / 19
+
+// Address is a row of the address table.
+type Address struct {
+	Lon      float64
+	Lat      float64
+	Number   string
+	Street   string
+	Unit     string
+	City     string
+	District string
+	Region   string
+	Postcode string
+}
+
+// args returns the arguments that bind an InsertValues row.
+func (a *Address) args() []interface{} {
+	return []interface{}{
+		a.Lon, a.Lat, a.Lon, a.Lat, a.Lon, a.Lat, a.Lon, a.Lat,
+		a.Number, a.Street, a.Unit, a.City, a.District, a.Region, a.Postcode,
+	}
+}
+
+// tsvEscaper escapes the characters that LoadInfileQuery treats specially.
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// writeTSV writes the address as a line of LoadInfileQuery's input, ending
+// with its geohash and s2cell if the input has them.
+func (a *Address) writeTSV(w *bytes.Buffer, geohash, s2cell bool) {
+	w.WriteString(strconv.FormatFloat(a.Lon, 'f', -1, 64))
+	w.WriteByte('\t')
+	w.WriteString(strconv.FormatFloat(a.Lat, 'f', -1, 64))
+	for _, field := range []string{a.Number, a.Street, a.Unit, a.City, a.District, a.Region, a.Postcode} {
+		w.WriteByte('\t')
+		tsvEscaper.WriteString(w, field)
+	}
+	if geohash {
+		w.WriteByte('\t')
+		w.WriteString(a.geohash())
+	}
+	if s2cell {
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatInt(a.s2cell(), 10))
+	}
+	w.WriteByte('\n')
+}
+
+// geohash returns the geohash of the address, as the loader writes it.
+func (a *Address) geohash() string {
+	return GeohashEncode(Point{a.Lon, a.Lat}, GeohashPrecision)
+}
+
+// s2cell returns the ID of the leaf cell of the address as a signed integer,
+// as the loader writes it.
+func (a *Address) s2cell() int64 {
+	return int64(CellIDFromPoint(Point{a.Lon, a.Lat}))
+}
+
+// RowError is returned by a row parser for a row that cannot be loaded. The
+// row is rejected and the load carries on.
+type RowError struct {
+	Kind   string // short category, such as columns, coordinates or encoding
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	return e.Reason
+}
+
+// rowErrorf returns a RowError of the given kind.
+func rowErrorf(kind, format string, args ...interface{}) *RowError {
+	return &RowError{kind, fmt.Sprintf(format, args...)}
+}
+
+// loadBatch is a batch of addresses, and the position in the source just
+// after its last row.
+type loadBatch struct {
+	seq       int64
+	addresses []Address
+	rows      int64 // data rows read, including rejects, through this batch
+	offset    int64 // input offset just after this batch
+}
+
+// Loader writes addresses to the address table in batches. Each batch is
+// committed in its own transaction, either as a multi-row INSERT or with
+// LOAD DATA LOCAL INFILE, and batches are written by parallel workers.
+//
+// Rows that fail to parse are passed to Rejects rather than stopping the
+// load. If Checkpoint is set, the position after the last batch committed
+// in order is saved to it, so that a failed load can be resumed from there.
+type Loader struct {
+	DB         *sql.DB
+	Table      string
+	BatchSize  int  // addresses per batch
+	Workers    int  // number of parallel writers
+	Infile     bool // use LOAD DATA LOCAL INFILE rather than INSERT
+	Geog       bool // also write the SRID 4326 geog column
+	Geohash    bool // also write the geohash column
+	S2Cell     bool // also write the s2cell column
+	Quiet      bool // don't report progress
+	Source     string
+	Rejects    *RejectWriter
+	Checkpoint *Checkpoint
+	StartRow   int64 // data rows of the source already read before records
+	StartOff   int64 // input offset of the source where records starts
+
+	loaded int64 // addresses committed so far, updated atomically
+	seq    int64 // names the readers registered for LOAD DATA
+
+	mu        sync.Mutex
+	committed map[int64]*loadBatch // batches committed ahead of nextSeq
+	nextSeq   int64                // the first batch not yet committed
+}
+
+// Load reads every record from records, converts it with parse, and writes
+// the addresses to the table. It returns the number of addresses committed.
+// The first error from a writer, or reading records, stops the load.
+func (l *Loader) Load(records *csv.Reader, parse func(row []string) (Address, error)) (int64, error) {
+	if l.BatchSize < 1 || (!l.Infile && l.BatchSize > MaxInsertBatch) {
+		return 0, fmt.Errorf("batch size must be between 1 and %d", MaxInsertBatch)
+	}
+	if l.Workers < 1 {
+		l.Workers = 1
+	}
+	if l.Rejects == nil {
+		l.Rejects, _ = NewRejectWriter("", false)
+	}
+	l.committed = make(map[int64]*loadBatch)
+
+	batches := make(chan *loadBatch, l.Workers)
+	done := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < l.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := l.write(batch.addresses); err != nil {
+					fail(err)
+					return
+				}
+				atomic.AddInt64(&l.loaded, int64(len(batch.addresses)))
+				if err := l.commit(batch); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	stopProgress := l.progress(start)
+
+	send := func(batch *loadBatch) bool {
+		select {
+		case batches <- batch:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	rowNum := l.StartRow
+	batch := &loadBatch{}
+	for {
+		row, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		rowNum++
+		if perr, ok := err.(*csv.ParseError); ok {
+			if err := l.Rejects.Reject(l.Source, rowNum, "csv", perr.Error(), row); err != nil {
+				fail(err)
+				break
+			}
+			continue
+		}
+		if err != nil {
+			fail(err)
+			break
+		}
+		a, err := parse(row)
+		if err != nil {
+			kind := "invalid"
+			if rerr, ok := err.(*RowError); ok {
+				kind = rerr.Kind
+			}
+			if err := l.Rejects.Reject(l.Source, rowNum, kind, err.Error(), row); err != nil {
+				fail(err)
+				break
+			}
+			continue
+		}
+		batch.addresses = append(batch.addresses, a)
+		if len(batch.addresses) == l.BatchSize {
+			batch.rows = rowNum
+			batch.offset = l.StartOff + records.InputOffset()
+			if !send(batch) {
+				break
+			}
+			batch = &loadBatch{seq: batch.seq + 1}
+		}
+	}
+	if len(batch.addresses) > 0 {
+		batch.rows = rowNum
+		batch.offset = l.StartOff + records.InputOffset()
+		send(batch)
+	}
+	close(batches)
+	wg.Wait()
+	stopProgress()
+
+	if firstErr == nil {
+		firstErr = l.Checkpoint.Save(l.Source, SourceProgress{Rows: rowNum, Offset: l.StartOff + records.InputOffset(), Done: true})
+	}
+
+	loaded := atomic.LoadInt64(&l.loaded)
+	if !l.Quiet {
+		elapsed := time.Since(start)
+		fmt.Printf("Loaded %v rows in %s (%.0f rows/s)\n", Green("%d", loaded), elapsed.Round(time.Millisecond), float64(loaded)/elapsed.Seconds())
+	}
+	return loaded, firstErr
+}
+
+// commit records that batch has been committed. Batches commit out of order
+// when there are several writers, so the checkpoint only advances past a
+// batch once every batch before it has committed too.
+func (l *Loader) commit(batch *loadBatch) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.committed[batch.seq] = batch
+	var last *loadBatch
+	for {
+		b, ok := l.committed[l.nextSeq]
+		if !ok {
+			break
+		}
+		delete(l.committed, l.nextSeq)
+		l.nextSeq++
+		last = b
+	}
+	if last == nil {
+		return nil
+	}
+	return l.Checkpoint.Save(l.Source, SourceProgress{Rows: last.rows, Offset: last.offset})
+}
+
+// progress prints the load rate every second until the returned function is
+// called.
+func (l *Loader) progress(start time.Time) func() {
+	if l.Quiet {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		last := int64(0)
+		for {
+			select {
+			case <-stop:
+				fmt.Println()
+				return
+			case <-ticker.C:
+				loaded := atomic.LoadInt64(&l.loaded)
+				fmt.Printf("\r%v rows, %v rows/s, %v rows/s overall   ", Green("%d", loaded), Cyan("%d", loaded-last),
+					Yellow("%.0f", float64(loaded)/time.Since(start).Seconds()))
+				last = loaded
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// write commits a batch of addresses in a single transaction.
+func (l *Loader) write(batch []Address) error {
+	if l.Infile {
+		return l.loadInfile(batch)
+	}
+
+	var columns, extra string
+	if l.Geog {
+		columns += GeogColumn
+		extra += GeogValue
+	}
+	if l.Geohash {
+		columns += GeohashColumn
+		extra += GeohashValue
+	}
+	if l.S2Cell {
+		columns += S2CellColumn
+		extra += S2CellValue
+	}
+	row := fmt.Sprintf(InsertValues, extra)
+	values := make([]string, len(batch))
+	args := make([]interface{}, 0, 19*len(batch))
+	for i := range batch {
+		values[i] = row
+		args = append(args, batch[i].args()...)
+		if l.Geog {
+			args = append(args, batch[i].Lon, batch[i].Lat)
+		}
+		if l.Geohash {
+			args = append(args, batch[i].geohash())
+		}
+		if l.S2Cell {
+			args = append(args, batch[i].s2cell())
+		}
+	}
+
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(InsertQuery, QuoteTable(l.Table), strings.Join(values, ", "), columns), args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadInfile commits a batch of addresses with LOAD DATA LOCAL INFILE,
+// streaming them from memory through a reader registered with the driver.
+// The server must have local_infile enabled.
+func (l *Loader) loadInfile(batch []Address) error {
+	var buf bytes.Buffer
+	for i := range batch {
+		batch[i].writeTSV(&buf, l.Geohash, l.S2Cell)
+	}
+	name := fmt.Sprintf("geospatial-%d", atomic.AddInt64(&l.seq, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader { return bytes.NewReader(buf.Bytes()) })
+	defer mysql.DeregisterReaderHandler(name)
+
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	var input, assignment string
+	if l.Geohash {
+		input += GeohashInput
+	}
+	if l.S2Cell {
+		input += S2CellInput
+	}
+	if l.Geog {
+		assignment = GeogAssignment
+	}
+	if _, err := tx.Exec(fmt.Sprintf(LoadInfileQuery, name, QuoteTable(l.Table), input, assignment)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load CSV files, gzipped CSV files, zip archives of CSV files, or
+// directories of them into the MySQL database
+func (gc *GeoCommand) Load(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+	db.SetMaxOpenConns(gc.Workers)
+	db.SetMaxIdleConns(gc.Workers)
+
+	sources, err := FindSources(gc.Inputs, gc.Include, gc.Exclude)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no CSV sources found in %s", strings.Join(gc.Inputs, ", "))
+	}
+
+	overrides := make(map[string]string)
+	if gc.MappingFile != "" {
+		if overrides, err = ReadColumnMapping(gc.MappingFile); err != nil {
+			return err
+		}
+	}
+	for field, column := range gc.Columns {
+		overrides[field] = column
+	}
+
+	// Tables migrated on MySQL 8 have an SRID 4326 geog column to fill too.
+	geog, err := ColumnExists(gc.Schema, gc.Table, "geog", db)
+	if err != nil {
+		return err
+	}
+	if geog {
+		fmt.Printf("Writing the %s column\n", Cyan("geog"))
+	}
+	// The geohash and s2cell columns are filled on request.
+	for _, optional := range []struct {
+		column string
+		fill   bool
+	}{{"geohash", gc.Geohash}, {"s2cell", gc.S2Cell}} {
+		column := optional.column
+		if !optional.fill {
+			continue
+		}
+		exists, err := ColumnExists(gc.Schema, gc.Table, column, db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%s has no %s column; run migrate up first", gc.Table, column)
+		}
+		fmt.Printf("Writing the %s column\n", Cyan("%s", column))
+	}
+
+	var checkpoint *Checkpoint
+	if gc.CheckpointFile != "" {
+		if checkpoint, err = OpenCheckpoint(gc.CheckpointFile); err != nil {
+			return err
+		}
+	}
+	// A load resuming from a checkpoint keeps the rows already rejected.
+	resume := checkpoint != nil && len(checkpoint.Sources) > 0
+	rejects, err := NewRejectWriter(gc.RejectFile, resume)
+	if err != nil {
+		return err
+	}
+	defer rejects.Close()
+
+	var total int64
+	for i, source := range sources {
+		if !gc.Quiet {
+			fmt.Printf("Loading %s [%v/%v]\n", Cyan("%s", source.Path), i+1, len(sources))
+		}
+		loaded, err := gc.loadSource(&source, overrides, geog, checkpoint, rejects, db)
+		total += loaded
+		if err != nil {
+			return fmt.Errorf("%s: %v", source.Path, err)
+		}
+	}
+	// Quiet loads print only this summary.
+	if len(sources) > 1 || gc.Quiet {
+		fmt.Printf("Loaded %v rows from %d sources\n", Green("%d", total), len(sources))
+	}
+	if count, kinds := rejects.Count(); count > 0 {
+		var names []string
+		for kind := range kinds {
+			names = append(names, kind)
+		}
+		sort.Strings(names)
+		fmt.Printf("Rejected %v rows\n", Red("%d", count))
+		for _, kind := range names {
+			fmt.Printf("  %s: %d\n", kind, kinds[kind])
+		}
+	}
+	return rejects.Close()
+}
+
+// loadSource loads a single source, mapping its columns by its header row.
+// If the checkpoint shows the source was partly loaded, the load resumes
+// after the rows already committed.
+func (gc *GeoCommand) loadSource(source *Source, overrides map[string]string, geog bool, checkpoint *Checkpoint, rejects *RejectWriter, db *sql.DB) (int64, error) {
+	progress := checkpoint.Progress(source.Path)
+	if progress.Done {
+		if !gc.Quiet {
+			fmt.Printf("%s already loaded, skipping\n", Cyan("%s", source.Path))
+		}
+		return 0, nil
+	}
+
+	in, err := source.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	// Open a buffered CSV reader. Column counts are checked against the
+	// header when mapping, so that a short row is rejected rather than
+	// stopping the load.
+	newReader := func(r io.Reader) *csv.Reader {
+		reader := csv.NewReader(bufio.NewReader(r))
+		reader.FieldsPerRecord = -1
+		return reader
+	}
+	reader := newReader(in)
+	// Map the columns by the header row
+	header, err := reader.Read()
+	if err != nil {
+		return 0, err
+	}
+	mapping, err := NewColumnMapping(header, overrides)
+	if err != nil {
+		return 0, err
+	}
+
+	var startOffset int64
+	if progress.Rows > 0 {
+		fmt.Printf("Resuming after row %d\n", progress.Rows)
+		if seeker, ok := in.(io.Seeker); ok {
+			// Plain files jump straight to the checkpoint.
+			if _, err := seeker.Seek(progress.Offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			reader = newReader(in)
+			startOffset = progress.Offset
+		} else {
+			// Compressed sources have to be read up to it.
+			for i := int64(0); i < progress.Rows; i++ {
+				if _, err := reader.Read(); err != nil {
+					if _, ok := err.(*csv.ParseError); !ok {
+						return 0, err
+					}
+				}
+			}
+		}
+	}
+
+	loader := &Loader{
+		DB:         db,
+		Table:      gc.Table,
+		BatchSize:  gc.BatchSize,
+		Workers:    gc.Workers,
+		Infile:     gc.Infile,
+		Geog:       geog,
+		Geohash:    gc.Geohash,
+		S2Cell:     gc.S2Cell,
+		Quiet:      gc.Quiet,
+		Source:     source.Path,
+		Rejects:    rejects,
+		Checkpoint: checkpoint,
+		StartRow:   progress.Rows,
+		StartOff:   startOffset,
+	}
+	country := source.Country
+	if gc.Country != "" {
+		country = gc.Country
+	}
+	pipeline := NewPipeline(gc.Checks, gc.Normalize, country, gc.Postal)
+	return loader.Load(reader, func(row []string) (Address, error) {
+		a, err := mapping.Address(row)
+		if err != nil {
+			return a, err
+		}
+		if a.Region == "" {
+			a.Region = source.Region
+		}
+		return a, pipeline.Apply(&a)
+	})
+}
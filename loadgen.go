@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// loadWorker holds the measurements of a single load generator worker. Each
+// worker records into its own histograms so that no locking is needed while
+// the load is running.
+type loadWorker struct {
+	response  *Histogram // latency as seen by the caller, corrected for coordinated omission
+	service   *Histogram // time the query itself took
+	perSecond []int64    // completed queries per second since the start
+	errors    int
+	firstErr  error
+}
+
+func newLoadWorker() *loadWorker {
+	return &loadWorker{response: NewHistogram(), service: NewHistogram()}
+}
+
+// done records a query that was intended to start at intended, actually
+// started at started and completed at finished.
+func (w *loadWorker) done(start, intended, started, finished time.Time, expectedInterval time.Duration, err error) {
+	if err != nil {
+		w.errors++
+		if w.firstErr == nil {
+			w.firstErr = err
+		}
+		return
+	}
+	w.response.RecordCorrected(finished.Sub(intended), expectedInterval)
+	w.service.Record(finished.Sub(started))
+	second := int(finished.Sub(start) / time.Second)
+	for len(w.perSecond) <= second {
+		w.perSecond = append(w.perSecond, 0)
+	}
+	w.perSecond[second]++
+}
+
+// runLoadQuery executes query and fetches every row, which is the unit of
+// work timed by the load generator.
+func runLoadQuery(s QueryStrategy, q loadQuery, db *sql.DB) error {
+	_, _, _, err := TimeQuery(s, q.query, q.args, db, nil)
+	return err
+}
+
+// loadQuery is a rendered query and its arguments.
+type loadQuery struct {
+	query string
+	args  []interface{}
+}
+
+// Stress drives a single query type from many concurrent workers sharing the
+// connection pool. With --rate 0 the workers run closed-loop, each issuing
+// its next query as soon as the previous one completes. With a rate the load
+// is open-loop: queries are scheduled at fixed intervals regardless of how
+// long earlier ones took, and latency is measured from the scheduled start
+// time, so queueing delay is not hidden.
+func (gc *GeoCommand) Stress(context *kingpin.ParseContext) error {
+	if gc.Workers < 1 {
+		return fmt.Errorf("stress needs at least 1 worker, not %d", gc.Workers)
+	}
+	db := gc.Connect()
+	defer db.Close()
+	db.SetMaxOpenConns(gc.Workers)
+	db.SetMaxIdleConns(gc.Workers)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	strategy, err := LookupStrategy(gc.QueryType)
+	if err != nil {
+		return err
+	}
+	if err := CheckRequirements(strategy, gc.Schema, gc.Table, db); err != nil {
+		return err
+	}
+
+	anchors, err := gc.AnchorPoints(rnd, db)
+	if err != nil {
+		return err
+	}
+	if len(anchors) == 0 {
+		return fmt.Errorf("stress needs at least 1 anchor to query around")
+	}
+	queries := make([]loadQuery, len(anchors))
+	for i, anchor := range anchors {
+		queries[i].query, queries[i].args = strategy.SQL(gc.Table, anchor, gc.Search())
+	}
+
+	mode := "closed-loop"
+	if gc.Rate > 0 {
+		mode = fmt.Sprintf("open-loop at %.1f queries/s", gc.Rate)
+	}
+	fmt.Printf("Running %s with %d workers for %s, %s\n", Green("%s", gc.QueryType), gc.Workers, gc.Duration, mode)
+
+	workers := make([]*loadWorker, gc.Workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(gc.Duration)
+
+	if gc.Rate > 0 {
+		// The scheduler computes each intended start time from the start of
+		// the run rather than from the clock, so falling behind shows up as
+		// latency instead of silently lowering the offered load.
+		interval := time.Duration(float64(time.Second) / gc.Rate)
+		schedule := make(chan time.Time, gc.Workers*16)
+		go func() {
+			defer close(schedule)
+			for i := 0; ; i++ {
+				intended := start.Add(time.Duration(i) * interval)
+				if !intended.Before(deadline) {
+					return
+				}
+				time.Sleep(time.Until(intended))
+				schedule <- intended
+			}
+		}()
+		for i := range workers {
+			workers[i] = newLoadWorker()
+			wg.Add(1)
+			go func(w *loadWorker, n int) {
+				defer wg.Done()
+				for intended := range schedule {
+					started := time.Now()
+					err := runLoadQuery(strategy, queries[n%len(queries)], db)
+					w.done(start, intended, started, time.Now(), 0, err)
+					n++
+				}
+			}(workers[i], i)
+		}
+	} else {
+		for i := range workers {
+			workers[i] = newLoadWorker()
+			wg.Add(1)
+			go func(w *loadWorker, n int) {
+				defer wg.Done()
+				for time.Now().Before(deadline) {
+					started := time.Now()
+					err := runLoadQuery(strategy, queries[n%len(queries)], db)
+					w.done(start, started, started, time.Now(), gc.ExpectedInterval, err)
+					n++
+				}
+			}(workers[i], i)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	response := NewHistogram()
+	service := NewHistogram()
+	var perSecond []int64
+	errors := 0
+	var firstErr error
+	for _, w := range workers {
+		response.Merge(w.response)
+		service.Merge(w.service)
+		for len(perSecond) < len(w.perSecond) {
+			perSecond = append(perSecond, 0)
+		}
+		for s, c := range w.perSecond {
+			perSecond[s] += c
+		}
+		errors += w.errors
+		if firstErr == nil {
+			firstErr = w.firstErr
+		}
+	}
+
+	if !gc.Quiet {
+		for s, c := range perSecond {
+			fmt.Printf("%4ds %6d queries/s\n", s+1, c)
+		}
+	}
+	completed := service.Count()
+	fmt.Printf("\n%d queries in %s (%.1f queries/s), %v errors\n", completed, elapsed.Round(time.Millisecond), float64(completed)/elapsed.Seconds(), Red("%d", errors))
+	if firstErr != nil {
+		fmt.Printf("First error: %v\n", firstErr)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "latency\tcount\tmin\tmean\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, h := range []struct {
+		name string
+		hist *Histogram
+	}{{"response", response}, {"service", service}} {
+		fmt.Fprintf(w, "%s\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", h.name, h.hist.Count(), h.hist.Min(), h.hist.Mean(),
+			h.hist.Percentile(50), h.hist.Percentile(90), h.hist.Percentile(99), h.hist.Percentile(99.9), h.hist.Max())
+	}
+	return w.Flush()
+}
@@ -3,14 +3,12 @@
 package main
 
 import (
-	"bufio"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -29,9 +27,74 @@ type GeoCommand struct {
 	Quiet     bool     // whether to show non-error output
 	Lon       string   // Longitude to use for Select
 	Lat       string   // Latitude to use for Select
-	InFile    *os.File // File name to use for Load
-	Postal    string   // Postal code to prepend to the file's codes for Load
-	QueryType string   // Type of query to use for Select.  Can be inline, stored, or spatial
+	Inputs    []string // Files, archives and directories to use for Load
+	Include   []string // Globs selecting the CSV sources to Load
+	Exclude   []string // Globs excluding CSV sources from Load
+	Postal    string   // Prefix to prepend to the postcodes of rows for Load
+	QueryType string   // Type of query to use for Select. One of the registered strategies
+
+	Queries       []string // Query types to run for Benchmark.  Defaults to all
+	Anchors       []string // lon,lat anchor points to use for Benchmark
+	RandomAnchors int      // Number of random anchor rows to use when no anchors are given
+	Warmup        int      // Number of discarded warmup rounds for Benchmark
+	Iterations    int      // Number of measured rounds for Benchmark
+	Radius        float64  // Search radius. 0 returns every row
+	Unit          string   // Unit of the search radius and the distances returned, one of LengthUnits
+	Earth         string   // Earth model distances are measured on, one of EarthModels
+	Limit         int      // Maximum number of rows a search returns. 0 returns every row
+	Order         string   // Order of the rows a search returns: distance, id or none
+	ResultsFile   string   // .json or .csv file to write Select and Benchmark results to
+
+	CompareFiles []string // Results files for Compare. The first is the baseline
+	Phase        string   // Timing phase to compare: query, fetch or total
+	Method       string   // Significance test for Compare: mannwhitney or bootstrap
+	Threshold    float64  // Slowdown, in percent, that counts as a regression
+	Alpha        float64  // Significance level for Compare
+	Force        bool     // Compare runs whose search parameters differ
+
+	Workers          int           // Number of concurrent workers for Stress and Load
+	Duration         time.Duration // How long Stress runs for
+	Rate             float64       // Open-loop arrival rate for Stress in queries/s. 0 runs closed-loop
+	ExpectedInterval time.Duration // Expected time between closed-loop queries, for coordinated omission correction
+
+	BatchSize int  // Addresses per batch for Load
+	Infile    bool // Load with LOAD DATA LOCAL INFILE rather than INSERT
+	Geohash   bool // Fill the geohash column in Load
+	S2Cell    bool // Fill the s2cell column in Load
+
+	Columns     map[string]string // Address field to CSV header overrides for Load
+	MappingFile string            // File of field=HEADER column mappings for Load
+
+	CheckpointFile string   // File recording Load progress, to resume from
+	RejectFile     string   // CSV file that rows rejected by Load are written to
+	Checks         []string // Checks that reject rows in Load
+	Normalize      []string // Normalizations applied to rows in Load
+	Country        string   // Country code of Load's sources, for the swapped check
+
+	Steps int // Number of migrations to roll back
+
+	Matrix         bool     // Benchmark across the variant tables
+	VariantEngines []string // Storage engines of the variant tables
+	VariantTypes   []string // Coordinate column types of the variant tables
+	VariantIndexes []string // Spatial indexes of the variant tables: spatial or none
+	VariantSRIDs   []string // SRIDs of the variant tables
+
+	Pairs      int    // Point pairs per distance band for Accuracy
+	PairSource string // Where Accuracy takes its pairs from: synthetic or table
+	Local      bool   // Whether Accuracy compares only the Go formulas, without the database
+
+	Resolution    int    // Hex grid resolution for HexBin and density anchors
+	HexSource     string // Rows HexBin aggregates: addresses or feed
+	Top           int    // Number of the densest cells HexBin lists
+	HexFile       string // CSV file to write HexBin's cells to
+	AnchorDensity string // Where random anchors are picked: any, dense or sparse cells
+
+	Memory     bool     // Benchmark the in-memory R-tree and k-d tree baselines too
+	PointFiles []string // CSV files or directories to build the in-memory baselines from, rather than the table
+
+	StartRadius float64 // First radius a Nearest search tries. 0 for the default
+	Check       bool    // Check Nearest's rows against an in-memory k-d tree
+	Explain     bool    // Print the SQL Select runs, and its arguments
 }
 
 // Distance calculates the distance between two points
@@ -40,123 +103,79 @@ func (gc *GeoCommand) Distance(context *kingpin.ParseContext) error {
 	dlat := 47.2261598
 	dtlon, _ := strconv.ParseFloat(gc.Lon, 64)
 	dtlat, _ := strconv.ParseFloat(gc.Lat, 64)
-
-	fmt.Printf("Method 1: %f\nMethod 2: %f\n", Distance(dlon, dlat, dtlon, dtlat), Distance2(dlon, dlat, dtlon, dtlat))
-	return nil
-}
-
-// Load a file into the MySQL database
-func (gc *GeoCommand) Load(ctx *kingpin.ParseContext) error {
-
-	db := gc.Connect()
-
-	// Open a buffered CSV reader
-	reader := csv.NewReader(bufio.NewReader(gc.InFile))
-	// Read off the header row
-	_, err := reader.Read()
+	unit, earth, err := gc.Search().Model()
 	if err != nil {
-		log.Fatal(err.Error())
 		return err
 	}
 
-	for {
-		row, err := reader.Read()
-
-		if err == io.EOF {
-			// Done
-			break
-		}
-
-		// IF a postal code was provided, it should be prepended to the
-		// postal code in the row.
-		p := ""
-		if gc.Postal != "" {
-			p = fmt.Sprintf("%s-", gc.Postal)
-		} else {
-			p = row[8]
-		}
-
-		// Set the table selection
-		query := fmt.Sprintf(InsertQuery, gc.Table,
-			row[0],
-			row[1],
-			row[2],
-			row[3],
-			row[4],
-			row[5],
-			row[6],
-			row[7],
-			p)
-		_, err = db.Exec(query)
-
-		if err != nil {
-			fmt.Println(row)
-			fmt.Println(query)
-			return err
-		}
+	// Distance and Distance2 measure on the mean sphere; rescale them to
+	// the model's.
+	sphere := func(km float64) float64 {
+		return earth.Sphere(km / Rk).In(unit)
+	}
+	fmt.Printf("Method 1: %f %s\nMethod 2: %f %s\n",
+		sphere(Distance(dlon, dlat, dtlon, dtlat)), gc.Unit, sphere(Distance2(dlon, dlat, dtlon, dtlat)), gc.Unit)
+	if earth.Ellipsoid != nil {
+		fmt.Printf("Ellipsoid: %f %s\n", earth.Distance(Point{dlon, dlat}, Point{dtlon, dtlat}).In(unit), gc.Unit)
 	}
-
-	return nil
-}
-
-// Benchmark runs the benchmark tests
-func (gc *GeoCommand) Benchmark(context *kingpin.ParseContext) error {
-	db := gc.Connect()
-	defer db.Close()
 	return nil
 }
 
-// Select uses the query statement above to fetch rows by the lon/lat provided
+// Select uses the chosen query strategy to fetch rows by the lon/lat provided
 // on the command line.
 func (gc *GeoCommand) Select(context *kingpin.ParseContext) error {
 	db := gc.Connect()
 	defer db.Close()
 
-	var query string
-	if gc.QueryType == "inline" {
-		query = InlineQuery
-	} else if gc.QueryType == "stored" {
-		query = StoredFuncQuery
-	} else if gc.QueryType == "spatial" {
-		query = SpatialFuncQuery
-	} else {
-		panic(fmt.Sprintf("GeoCommand.Select - unknown query type: %s\n", gc.QueryType))
+	strategy, err := LookupStrategy(gc.QueryType)
+	if err != nil {
+		return err
 	}
-
-	// Search the database for the username provided
-	// If it exists grab the password for validation
-	q := fmt.Sprintf(query, gc.Table, gc.Lon, gc.Lat)
-	fmt.Printf("%s\n", q)
-	start := time.Now()
-	rows, err := db.Query(q)
-	queryTime := time.Since(start)
-
+	anchor, err := ParsePoint(gc.Lon + "," + gc.Lat)
+	if err != nil {
+		return err
+	}
+	results, err := gc.NewResults("select", db)
 	if err != nil {
-		log.Fatal(err.Error())
 		return err
 	}
 
-	counter := 0
-	start = time.Now()
-	for rows.Next() {
-		counter++
+	q, args := strategy.SQL(gc.Table, anchor, gc.Search())
+	if gc.Explain {
+		fmt.Printf("%s\n%v\n", Cyan("%s", q), args)
+	}
+	queryTime, fetchTime, count, err := TimeQuery(strategy, q, args, db, func(m Match) {
 		if !gc.Quiet {
-			var (
-				rID      string
-				distance float64
-			)
-			if err := rows.Scan(&rID, &distance); err != nil {
-				log.Fatal(err)
-			}
-
-			fmt.Fprintf(os.Stdout, "%s - %f\n", rID, distance)
+			fmt.Fprintf(os.Stdout, "%s - %f\n", m.ID, m.Distance.Float64)
 		}
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+		return err
 	}
-	fetchTime := time.Since(start)
+	results.Add(Measurement{
+		QueryType: gc.QueryType,
+		Anchor:    anchor,
+		Iteration: 1,
+		QueryTime: queryTime,
+		FetchTime: fetchTime,
+		Rows:      count,
+	})
+	if gc.ResultsFile != "" {
+		if err := results.Write(gc.ResultsFile); err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("Query time: %s  Fetch time: %s\n", queryTime, fetchTime)
 	return nil
 }
 
+// Search returns the radius search described by the command line.
+func (gc *GeoCommand) Search() Search {
+	return Search{Radius: gc.Radius, Unit: gc.Unit, Earth: gc.Earth, Limit: gc.Limit, Order: gc.Order}
+}
+
 // Seed generates feeds with comments for a random number of days
 func (gc *GeoCommand) Seed(context *kingpin.ParseContext) error {
 	db := gc.Connect()
@@ -171,6 +190,58 @@ func (gc *GeoCommand) Seed(context *kingpin.ParseContext) error {
 	return nil
 }
 
+// searchFlags adds the radius search flags shared by the query commands.
+func searchFlags(cmd *kingpin.CmdClause, gc *GeoCommand, radius, order string) {
+	cmd.Flag("radius", "Only return rows within this distance. 0 returns every row").
+		Default(radius).
+		Float64Var(&gc.Radius)
+	unitFlags(cmd, gc)
+	cmd.Flag("limit", "Maximum number of rows to return. 0 returns every row").
+		Default("0").
+		IntVar(&gc.Limit)
+	cmd.Flag("order", "Order of the rows returned [distance, id, none]").
+		Default(order).
+		EnumVar(&gc.Order, "distance", "id", "none")
+}
+
+// unitFlags adds the flags choosing the unit and Earth model of distances.
+func unitFlags(cmd *kingpin.CmdClause, gc *GeoCommand) {
+	cmd.Flag("unit", "Unit of distances ["+strings.Join(LengthUnitNames, ", ")+"]").
+		Default("km").
+		EnumVar(&gc.Unit, LengthUnitNames...)
+	cmd.Flag("earth", "Earth model distances are measured on ["+EarthModelHelp()+"]").
+		Default(MeanEarth.Name).
+		EnumVar(&gc.Earth, EarthModelNames()...)
+}
+
+// densityFlags adds the flags that pick random anchors by the density of
+// the address table around them.
+func densityFlags(cmd *kingpin.CmdClause, gc *GeoCommand) {
+	cmd.Flag("density", "Pick random anchors from any row, or one each from the densest or sparsest hex cells [any, dense, sparse]").
+		Default("any").
+		EnumVar(&gc.AnchorDensity, "any", "dense", "sparse")
+	cmd.Flag("resolution", "Hex grid resolution the density of anchors is measured at, from 0 to 15").
+		Default("7").
+		IntVar(&gc.Resolution)
+}
+
+// variantFlags adds the flags that select variant tables to cmd. Each
+// defaults to every value, so the full matrix is selected.
+func variantFlags(cmd *kingpin.CmdClause, gc *GeoCommand) {
+	cmd.Flag("engine", "Storage engine of the variants. Repeat for several").
+		Default(VariantEngines...).
+		EnumsVar(&gc.VariantEngines, VariantEngines...)
+	cmd.Flag("type", "Type of the variants' coordinate columns. Repeat for several").
+		Default(VariantTypes...).
+		EnumsVar(&gc.VariantTypes, VariantTypes...)
+	cmd.Flag("index", "Whether the variants have a spatial index. Repeat for several").
+		Default(VariantIndexes...).
+		EnumsVar(&gc.VariantIndexes, VariantIndexes...)
+	cmd.Flag("srid", "SRID of the variants' geom column. 4326 needs MySQL 8. Repeat for several").
+		Default(VariantSRIDs...).
+		EnumsVar(&gc.VariantSRIDs, VariantSRIDs...)
+}
+
 func configureApp(app *kingpin.Application) {
 	gc := &GeoCommand{}
 
@@ -205,17 +276,80 @@ func configureApp(app *kingpin.Application) {
 	selectCmd.Arg("lat", "Latitude in the form degrees.minutes [DD.MMMMMMMM]").
 		Required().
 		StringVar(&gc.Lat)
-	selectCmd.Flag("query", "Type of query to use [stored, inline, spatial]").
+	selectCmd.Flag("query", "Type of query to use ["+StrategyHelp()+"]").
 		Required().
-		EnumVar(&gc.QueryType, "inline", "stored", "spatial")
+		EnumVar(&gc.QueryType, StrategyNames()...)
+	searchFlags(selectCmd, gc, "0", "distance")
+	selectCmd.Flag("explain", "Print the SQL query and its arguments").
+		BoolVar(&gc.Explain)
+	selectCmd.Flag("out", "Write the results to a .json or .csv file").
+		StringVar(&gc.ResultsFile)
+
+	// Nearest command and args
+	nearestCmd := app.Command("nearest", "Find the rows nearest to a location by lon lat.").Action(gc.Nearest)
+	nearestCmd.Arg("lon", "Longitude in the form degrees.minutes [DD.MMMMMMMM]").
+		Required().
+		StringVar(&gc.Lon)
+	nearestCmd.Arg("lat", "Latitude in the form degrees.minutes [DD.MMMMMMMM]").
+		Required().
+		StringVar(&gc.Lat)
+	nearestCmd.Flag("k", "Number of rows to return").
+		Default("10").
+		IntVar(&gc.Limit)
+	nearestCmd.Flag("query", "Type of query to use. Those with an index search an expanding radius ["+StrategyHelp()+"]").
+		Default("bbox").
+		EnumVar(&gc.QueryType, StrategyNames()...)
+	nearestCmd.Flag("start", "First radius to search. 0 starts from 1 km").
+		Default("0").
+		Float64Var(&gc.StartRadius)
+	nearestCmd.Flag("max-radius", "Never return rows beyond this distance. 0 for no limit").
+		Default("0").
+		Float64Var(&gc.Radius)
+	unitFlags(nearestCmd, gc)
+	nearestCmd.Flag("check", "Check the rows against an in-memory k-d tree of the table").
+		BoolVar(&gc.Check)
+	nearestCmd.Flag("out", "Write the results to a .json or .csv file").
+		StringVar(&gc.ResultsFile)
 
 	// Load command and args
-	loadCmd := app.Command("load", "Load data from a CSV file.").Action(gc.Load)
-	loadCmd.Flag("postal", "PostalCode to prepend to file postal codes").
+	loadCmd := app.Command("load", "Load data from CSV files, zip archives or directories.").Action(gc.Load)
+	loadCmd.Flag("postal", "Prefix to prepend, with a dash, to the postcode of every row").
 		StringVar(&gc.Postal)
-	loadCmd.Arg("file", "File to load").
+	loadCmd.Flag("check", "Checks that reject rows: range, null-island, swapped, length, or none. Repeat for several").
+		Default(RowChecks...).
+		EnumsVar(&gc.Checks, append(RowChecks, "none")...)
+	loadCmd.Flag("normalize", "Normalizations applied to rows: space, case, or none. Repeat for several").
+		Default(RowNormalizers...).
+		EnumsVar(&gc.Normalize, append(RowNormalizers, "none")...)
+	loadCmd.Flag("country", "Country code of the sources, for the swapped check. Defaults to the country of each source's path").
+		StringVar(&gc.Country)
+	loadCmd.Flag("batch", "Number of rows per INSERT and transaction").
+		Default("1000").
+		IntVar(&gc.BatchSize)
+	loadCmd.Flag("workers", "Number of parallel writers").
+		Default("4").
+		IntVar(&gc.Workers)
+	loadCmd.Flag("column", "Map an address field to a CSV header, as field=HEADER. Repeat for several").
+		StringMapVar(&gc.Columns)
+	loadCmd.Flag("mapping", "File of field=HEADER lines mapping address fields to CSV headers").
+		ExistingFileVar(&gc.MappingFile)
+	loadCmd.Flag("checkpoint", "Record progress in this file, and resume from it if it exists").
+		StringVar(&gc.CheckpointFile)
+	loadCmd.Flag("rejects", "Write rows that cannot be loaded, and why, to this CSV file. A resumed load appends to it").
+		StringVar(&gc.RejectFile)
+	loadCmd.Flag("infile", "Use LOAD DATA LOCAL INFILE. The server must have local_infile enabled").
+		BoolVar(&gc.Infile)
+	loadCmd.Flag("geohash", "Fill the geohash column, added by migrate up, for the geohash query type").
+		BoolVar(&gc.Geohash)
+	loadCmd.Flag("s2cell", "Fill the s2cell column, added by migrate up, for the s2cell query type").
+		BoolVar(&gc.S2Cell)
+	loadCmd.Flag("include", "Only load CSV sources whose path or name matches this glob. Repeat for several").
+		StringsVar(&gc.Include)
+	loadCmd.Flag("exclude", "Skip CSV sources whose path or name matches this glob. Repeat for several").
+		StringsVar(&gc.Exclude)
+	loadCmd.Arg("files", "CSV, .csv.gz or .zip files, or directories of them, to load").
 		Required().
-		OpenFileVar(&gc.InFile, os.O_RDONLY, 0666)
+		ExistingFilesOrDirsVar(&gc.Inputs)
 
 	// Distance command
 	distCmd := app.Command("distance", "Calc distance between two points.").Action(gc.Distance)
@@ -225,8 +359,124 @@ func configureApp(app *kingpin.Application) {
 	distCmd.Arg("lat", "Latitude in the form degrees.minutes [DD.MMMMMMMM]").
 		Required().
 		StringVar(&gc.Lat)
+	unitFlags(distCmd, gc)
+
+	// Accuracy command
+	accuracyCmd := app.Command("accuracy", "Compare every distance method against the WGS 84 ellipsoid over bands of distances.").Action(gc.Accuracy)
+	accuracyCmd.Flag("pairs", "Number of point pairs per distance band").
+		Default("200").
+		IntVar(&gc.Pairs)
+	accuracyCmd.Flag("source", "Generate the pairs across the bands, or take them from random rows of the address table [synthetic, table]").
+		Default("synthetic").
+		EnumVar(&gc.PairSource, "synthetic", "table")
+	accuracyCmd.Flag("local", "Compare only the Go formulas, without the database").
+		BoolVar(&gc.Local)
+	accuracyCmd.Flag("earth", "Earth model of the spherical formulas ["+EarthModelHelp()+"]").
+		Default(MeanEarth.Name).
+		EnumVar(&gc.Earth, EarthModelNames()...)
+
+	// Benchmark command
+	benchCmd := app.Command("benchmark", "Time every query type against a set of anchor points.").Action(gc.Benchmark)
+	benchCmd.Flag("query", "Query type to run. Repeat for several. Defaults to all ["+StrategyHelp()+"]").
+		EnumsVar(&gc.Queries, StrategyNames()...)
+	benchCmd.Flag("anchor", "Anchor point in the form lon,lat. Repeat for several").
+		StringsVar(&gc.Anchors)
+	benchCmd.Flag("anchors", "Number of random anchor rows to use when no --anchor is given").
+		Default("5").
+		IntVar(&gc.RandomAnchors)
+	densityFlags(benchCmd, gc)
+	benchCmd.Flag("warmup", "Number of warmup rounds to run and discard").
+		Default("2").
+		IntVar(&gc.Warmup)
+	benchCmd.Flag("iterations", "Number of measured rounds").
+		Default("10").
+		IntVar(&gc.Iterations)
+	searchFlags(benchCmd, gc, "25", "none")
+	benchCmd.Flag("memory", "Also run the searches against an in-memory R-tree and k-d tree of the table's points").
+		BoolVar(&gc.Memory)
+	benchCmd.Flag("points", "Build the in-memory baselines from these CSV files or directories rather than the table. Repeat for several").
+		ExistingFilesOrDirsVar(&gc.PointFiles)
+	benchCmd.Flag("matrix", "Run every query type against the address table and each of its variant tables").
+		BoolVar(&gc.Matrix)
+	variantFlags(benchCmd, gc)
+	benchCmd.Flag("out", "Write the results to a .json or .csv file").
+		StringVar(&gc.ResultsFile)
+
+	// Stress command
+	stressCmd := app.Command("stress", "Drive one query type from concurrent workers.").Action(gc.Stress)
+	stressCmd.Flag("query", "Type of query to run ["+StrategyHelp()+"]").
+		Required().
+		EnumVar(&gc.QueryType, StrategyNames()...)
+	stressCmd.Flag("workers", "Number of concurrent workers").
+		Default("8").
+		IntVar(&gc.Workers)
+	stressCmd.Flag("duration", "How long to run for").
+		Default("30s").
+		DurationVar(&gc.Duration)
+	stressCmd.Flag("rate", "Open-loop arrival rate in queries/s. 0 runs closed-loop").
+		Default("0").
+		Float64Var(&gc.Rate)
+	stressCmd.Flag("expected-interval", "Closed-loop only: expected time between queries, used to correct for coordinated omission").
+		Default("0").
+		DurationVar(&gc.ExpectedInterval)
+	stressCmd.Flag("anchor", "Anchor point in the form lon,lat. Repeat for several").
+		StringsVar(&gc.Anchors)
+	stressCmd.Flag("anchors", "Number of random anchor rows to use when no --anchor is given").
+		Default("5").
+		IntVar(&gc.RandomAnchors)
+	densityFlags(stressCmd, gc)
+	searchFlags(stressCmd, gc, "25", "none")
+
+	// Hexbin command
+	hexbinCmd := app.Command("hexbin", "Count addresses or feed posts in the cells of a hexagonal grid.").Action(gc.HexBin)
+	hexbinCmd.Flag("resolution", "Grid resolution, from 0 to 15. Each finer resolution has cells a seventh the area").
+		Default("7").
+		IntVar(&gc.Resolution)
+	hexbinCmd.Flag("source", "Rows to count: the address table or the feed posts [addresses, feed]").
+		Default("addresses").
+		EnumVar(&gc.HexSource, "addresses", "feed")
+	hexbinCmd.Flag("top", "Number of the densest cells to list. 0 lists every cell").
+		Default("20").
+		IntVar(&gc.Top)
+	hexbinCmd.Flag("out", "Write every cell, with its count and boundary, to a CSV file").
+		StringVar(&gc.HexFile)
+
+	// Compare command
+	compareCmd := app.Command("compare", "Compare benchmark results files against a baseline.").Action(gc.Compare)
+	compareCmd.Flag("phase", "Timing phase to compare [query, fetch, total]").
+		Default("query").
+		EnumVar(&gc.Phase, "query", "fetch", "total")
+	compareCmd.Flag("method", "Significance test [mannwhitney, bootstrap]").
+		Default("mannwhitney").
+		EnumVar(&gc.Method, "mannwhitney", "bootstrap")
+	compareCmd.Flag("threshold", "Median slowdown, in percent, that counts as a regression").
+		Default("5").
+		Float64Var(&gc.Threshold)
+	compareCmd.Flag("alpha", "Significance level").
+		Default("0.05").
+		Float64Var(&gc.Alpha)
+	compareCmd.Flag("force", "Compare runs of different searches, with a warning, rather than refusing").
+		BoolVar(&gc.Force)
+	compareCmd.Arg("files", "Results files. The first one is the baseline").
+		Required().
+		ExistingFilesVar(&gc.CompareFiles)
 
 	app.Command("seed", "Seed the database with feeds and comments").Action(gc.Seed)
+
+	// Variant commands
+	variantsCmd := app.Command("variants", "Manage copies of the address table with other engines, column types, indexes and SRIDs.")
+	variantFlags(variantsCmd.Command("create", "Copy the address table into each variant table.").Action(gc.CreateVariants), gc)
+	variantFlags(variantsCmd.Command("drop", "Drop the variant tables.").Action(gc.DropVariants), gc)
+
+	// Schema commands
+	app.Command("init", "Create the schema if needed and apply every migration.").Action(gc.Init)
+	migrateCmd := app.Command("migrate", "Manage the schema's versioned migrations.")
+	migrateCmd.Command("up", "Apply the migrations not yet applied.").Default().Action(gc.MigrateUp)
+	migrateCmd.Command("status", "List the migrations and when each was applied.").Action(gc.MigrateStatus)
+	rollbackCmd := migrateCmd.Command("rollback", "Revert the latest applied migrations.").Action(gc.MigrateRollback)
+	rollbackCmd.Flag("steps", "Number of migrations to revert").
+		Default("1").
+		IntVar(&gc.Steps)
 }
 
 func main() {
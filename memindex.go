@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SpatialIndex is an in-memory index of points: the pure-Go baseline that
+// the SQL query types are benchmarked against. Distances are in kilometers
+// on the sphere of radius Rk, as Distance2 measures them.
+type SpatialIndex interface {
+	// Within returns the points within radius kilometers of p, in no
+	// particular order.
+	Within(p Point, radius float64) []Match
+	// Nearest returns the k points nearest to p, nearest first.
+	Nearest(p Point, k int) []Match
+	// Len returns the number of points in the index.
+	Len() int
+}
+
+// IndexedPoint is a point held by a SpatialIndex, and the id of its row.
+type IndexedPoint struct {
+	ID string
+	Point
+}
+
+// memoryIndex is a kind of SpatialIndex, and how to build one.
+type memoryIndex struct {
+	name        string // the query type its measurements are recorded as
+	description string
+	build       func(points []IndexedPoint) SpatialIndex
+}
+
+// MemoryIndexes are the in-memory baselines that benchmark --memory runs.
+var MemoryIndexes = []memoryIndex{
+	{"memory-rtree", "sort-tile-recursive R-tree over lon and lat, then Distance2", func(points []IndexedPoint) SpatialIndex {
+		return NewRTree(points)
+	}},
+	{"memory-kdtree", "k-d tree over unit vectors, then Distance2", func(points []IndexedPoint) SpatialIndex {
+		return NewKDTree(points)
+	}},
+}
+
+// IndexBuild records how long an in-memory index took to build, and how much
+// heap it holds beyond the points it was built from.
+type IndexBuild struct {
+	QueryType string        `json:"query_type"`
+	Points    int           `json:"points"`
+	BuildTime time.Duration `json:"build_time_ns"`
+	Memory    uint64        `json:"memory_bytes"`
+}
+
+// heapInUse collects garbage and returns the bytes of live heap objects.
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// BuildIndex builds the index mi over points, timing it and measuring the
+// heap it holds.
+func BuildIndex(mi memoryIndex, points []IndexedPoint) (SpatialIndex, IndexBuild) {
+	before := heapInUse()
+	start := time.Now()
+	index := mi.build(points)
+	build := IndexBuild{QueryType: mi.name, Points: index.Len(), BuildTime: time.Since(start)}
+	if after := heapInUse(); after > before {
+		build.Memory = after - before
+	}
+	return index, build
+}
+
+// TablePoints reads the id, lon and lat of every row of table.
+func TablePoints(table string, db *sql.DB) ([]IndexedPoint, error) {
+	if err := ValidateTable(table); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT id, lon, lat FROM %s;", QuoteTable(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []IndexedPoint
+	for rows.Next() {
+		var p IndexedPoint
+		if err := rows.Scan(&p.ID, &p.Lon, &p.Lat); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// FilePoints reads the points of the CSV sources in inputs, without the
+// database. Rows are checked as load checks them by default, and those load
+// would reject are skipped. Each point's id is its source and row number.
+func FilePoints(inputs []string) ([]IndexedPoint, error) {
+	sources, err := FindSources(inputs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no CSV sources found in %v", inputs)
+	}
+
+	var points []IndexedPoint
+	for _, source := range sources {
+		in, err := source.Open()
+		if err != nil {
+			return nil, err
+		}
+		reader := csv.NewReader(in)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("%s: %v", source.Path, err)
+		}
+		mapping, err := NewColumnMapping(header, nil)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("%s: %v", source.Path, err)
+		}
+		pipeline := NewPipeline(RowChecks, nil, source.Country, "")
+		for row := 1; ; row++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if _, ok := err.(*csv.ParseError); ok {
+					continue
+				}
+				in.Close()
+				return nil, fmt.Errorf("%s: %v", source.Path, err)
+			}
+			a, err := mapping.Address(record)
+			if err == nil {
+				err = pipeline.Apply(&a)
+			}
+			if err != nil {
+				continue
+			}
+			points = append(points, IndexedPoint{source.Path + ":" + strconv.Itoa(row), Point{a.Lon, a.Lat}})
+		}
+		in.Close()
+	}
+	return points, nil
+}
+
+// SearchIndex answers search around p from index the way the SQL query types
+// do: the distances are in the search's unit, on the sphere of its Earth
+// model, and the rows are ordered and limited alike. A search with no radius
+// for a limited number of rows in distance order is a k-nearest query.
+func SearchIndex(index SpatialIndex, p Point, search Search) ([]Match, error) {
+	unit, earth, err := search.Model()
+	if err != nil {
+		return nil, err
+	}
+	// The index measures on the sphere of radius Rk, so the radius is
+	// carried over to it, and the distances back.
+	scale := earth.Radius.In(unit) / Rk
+
+	var matches []Match
+	switch {
+	case search.Radius == 0 && search.Limit > 0 && search.Order == "distance":
+		matches = index.Nearest(p, search.Limit)
+	case search.Radius == 0:
+		matches = index.Within(p, math.Pi*Rk)
+	default:
+		matches = index.Within(p, search.Radius/scale)
+	}
+	for i := range matches {
+		matches[i].Distance.Float64 *= scale
+	}
+
+	switch search.Order {
+	case "distance":
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Distance.Float64 < matches[j].Distance.Float64 })
+	case "id":
+		// Table ids are numbers, so shorter ones come first.
+		sort.Slice(matches, func(i, j int) bool {
+			a, b := matches[i].ID, matches[j].ID
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return a < b
+		})
+	}
+	if search.Limit > 0 && len(matches) > search.Limit {
+		matches = matches[:search.Limit]
+	}
+	return matches, nil
+}
+
+// TimeIndex runs search around p against index, and returns how long it
+// took and the number of rows found. The rows are in memory once found, so
+// all the time is query time.
+func TimeIndex(index SpatialIndex, p Point, search Search) (time.Duration, int, error) {
+	start := time.Now()
+	matches, err := SearchIndex(index, p, search)
+	return time.Since(start), len(matches), err
+}
+
+// indexMatch returns the match for q, at distance kilometers.
+func indexMatch(q IndexedPoint, distance float64) Match {
+	return Match{ID: q.ID, Distance: sql.NullFloat64{Float64: distance, Valid: true}}
+}
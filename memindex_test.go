@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// testPoints returns points spread over the sphere, crowded by the
+// antimeridian near the north pole, and packed into one square degree.
+func testPoints(n int, rnd *rand.Rand) []IndexedPoint {
+	points := make([]IndexedPoint, n)
+	for i := range points {
+		var p Point
+		switch i % 3 {
+		case 0:
+			p = Point{rnd.Float64()*360 - 180, deg(math.Asin(rnd.Float64()*2 - 1))}
+		case 1:
+			p = Point{normalizeLon(179.5 + rnd.Float64()), 89 - rnd.Float64()*3}
+		case 2:
+			p = Point{11 + rnd.Float64(), 47 + rnd.Float64()}
+		}
+		points[i] = IndexedPoint{strconv.Itoa(i), p}
+	}
+	return points
+}
+
+// bruteForce returns every point measured from p, nearest first.
+func bruteForce(points []IndexedPoint, p Point) []Match {
+	matches := make([]Match, len(points))
+	for i, q := range points {
+		matches[i] = indexMatch(q, Distance2(p.Lon, p.Lat, q.Lon, q.Lat))
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Distance.Float64 < matches[j].Distance.Float64 })
+	return matches
+}
+
+var memoryIndexAnchors = []Point{{180, 88}, {-179.9, 89.9}, {11.5, 47.5}, {0, 0}, {-120, -60}, {179.99, -10}, {0, -90}}
+
+func TestMemoryIndexesWithin(t *testing.T) {
+	points := testPoints(6000, rand.New(rand.NewSource(1)))
+	for _, mi := range MemoryIndexes {
+		index := mi.build(points)
+		if index.Len() != len(points) {
+			t.Errorf("%s holds %d points of %d", mi.name, index.Len(), len(points))
+		}
+		for _, anchor := range memoryIndexAnchors {
+			all := bruteForce(points, anchor)
+			for _, radius := range []float64{1, 50, 300, 2000, 20000} {
+				want := make(map[string]bool)
+				for _, m := range all {
+					if m.Distance.Float64 <= radius {
+						want[m.ID] = true
+					}
+				}
+				got := index.Within(anchor, radius)
+				if len(got) != len(want) {
+					t.Errorf("%s found %d points within %g km of %v, want %d", mi.name, len(got), radius, anchor, len(want))
+					continue
+				}
+				for _, m := range got {
+					if !want[m.ID] {
+						t.Errorf("%s found point %s within %g km of %v, at %g km", mi.name, m.ID, radius, anchor, m.Distance.Float64)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMemoryIndexesNearest(t *testing.T) {
+	points := testPoints(6000, rand.New(rand.NewSource(2)))
+	for _, mi := range MemoryIndexes {
+		index := mi.build(points)
+		for _, anchor := range memoryIndexAnchors {
+			all := bruteForce(points, anchor)
+			for _, k := range []int{1, 10, 100, 1000} {
+				got := index.Nearest(anchor, k)
+				if len(got) != k {
+					t.Errorf("%s found %d of the %d points nearest %v", mi.name, len(got), k, anchor)
+					continue
+				}
+				// Ties aside, the points are those of brute force, in order.
+				for i, m := range got {
+					if math.Abs(m.Distance.Float64-all[i].Distance.Float64) > 1e-9 {
+						t.Errorf("%s: point %d nearest %v is at %g km, want %g", mi.name, i, anchor, m.Distance.Float64, all[i].Distance.Float64)
+						break
+					}
+				}
+			}
+		}
+		if got := index.Nearest(Point{}, len(points)+10); len(got) != len(points) {
+			t.Errorf("%s found %d points nearest of %d", mi.name, len(got), len(points))
+		}
+	}
+}
+
+func TestMemoryIndexesEmpty(t *testing.T) {
+	for _, mi := range MemoryIndexes {
+		index := mi.build(nil)
+		if index.Len() != 0 || len(index.Within(Point{}, 100)) != 0 || len(index.Nearest(Point{}, 3)) != 0 {
+			t.Errorf("%s found points without any", mi.name)
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	points := testPoints(3000, rand.New(rand.NewSource(3)))
+	index := NewKDTree(points)
+	anchor := Point{11.5, 47.5}
+	// Ten miles on the mean sphere.
+	radius := 10 * 1.609344
+	var want []Match
+	for _, m := range bruteForce(points, anchor) {
+		if m.Distance.Float64 <= radius {
+			want = append(want, m)
+		}
+	}
+	got, err := SearchIndex(index, anchor, Search{Radius: 10, Unit: "mi", Earth: "mean", Order: "distance", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 || len(want) < 5 {
+		t.Fatalf("found %d points, of %d within 10 mi", len(got), len(want))
+	}
+	for i, m := range got {
+		if math.Abs(m.Distance.Float64-want[i].Distance.Float64/1.609344) > 1e-9 {
+			t.Errorf("point %d is %g mi away, want %g", i, m.Distance.Float64, want[i].Distance.Float64/1.609344)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Migration is a versioned change to the schema. Its statements are run one
+// at a time, and those of a per-table migration are first formatted with the
+// address table name.
+type Migration struct {
+	Version  int
+	Name     string
+	PerTable bool   // applied once for each address table, rather than once per schema
+	Server   string // minimum MySQL version, if any. Skipped on older servers until they are upgraded
+	Up       []string
+	Down     []string
+}
+
+// scope returns the scope a migration is recorded under in
+// schema_migrations: the address table for per-table migrations, or the
+// empty string.
+func (m *Migration) scope(table string) string {
+	if m.PerTable {
+		return table
+	}
+	return ""
+}
+
+// Migrations lists the schema's migrations in version order. Add new
+// migrations to the end; never edit one that has been released.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_feed",
+		Up: []string{`CREATE TABLE feed (
+  id int(12) NOT NULL AUTO_INCREMENT,
+  user_id int(12) NOT NULL,
+  slug varchar(50) CHARACTER SET latin1 NOT NULL,
+  category varchar(20) CHARACTER SET latin1 NOT NULL,
+  content text CHARACTER SET latin1 NOT NULL,
+  image1 varchar(40) CHARACTER SET latin1 NOT NULL,
+  image2 varchar(40) CHARACTER SET latin1 NOT NULL,
+  image3 varchar(40) CHARACTER SET latin1 NOT NULL,
+  image4 varchar(40) CHARACTER SET latin1 NOT NULL,
+  image5 varchar(40) CHARACTER SET latin1 NOT NULL,
+  reactions_happy int(7) NOT NULL,
+  reactions_love int(7) NOT NULL,
+  reactions_funny int(7) NOT NULL,
+  reactions_shocked int(7) NOT NULL,
+  reactions_sad int(7) NOT NULL,
+  reactions_angry int(7) NOT NULL,
+  lat decimal(10,8) NOT NULL,
+  lng decimal(11,8) NOT NULL,
+  reviewed tinyint(1) NOT NULL,
+  date_created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  KEY lat (lat),
+  KEY lng (lng),
+  KEY category (category),
+  KEY user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`},
+		Down: []string{"DROP TABLE feed;"},
+	},
+	{
+		Version: 2,
+		Name:    "create_comments",
+		Up: []string{`CREATE TABLE comments (
+  id int(12) NOT NULL AUTO_INCREMENT,
+  parent_id int(12) NOT NULL,
+  user_id int(12) NOT NULL,
+  content text CHARACTER SET latin1 NOT NULL,
+  reviewed tinyint(1) NOT NULL,
+  date_created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  KEY parent_id (parent_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`},
+		Down: []string{"DROP TABLE comments;"},
+	},
+	{
+		Version:  3,
+		Name:     "create_address_table",
+		PerTable: true,
+		Up: []string{`CREATE TABLE %[1]s (
+  id INT NOT NULL AUTO_INCREMENT,
+  lon decimal(10,7) NOT NULL,
+  lat decimal(10,7) NOT NULL,
+  geom point NOT NULL,
+  rlon_d decimal(10, 7) NOT NULL COMMENT 'lon as decimal radians',
+  rlat_d decimal(10, 7) NOT NULL COMMENT 'lat as decimal radians',
+  rlon_dd double(10, 7) NOT NULL COMMENT 'lon as double radians',
+  rlat_dd double(10, 7) NOT NULL COMMENT 'lat as double radians',
+  number varchar(32) DEFAULT NULL,
+  street varchar(64) DEFAULT NULL,
+  unit varchar(8) DEFAULT NULL,
+  city varchar(64) DEFAULT NULL,
+  district varchar(64) DEFAULT NULL,
+  region varchar(64) DEFAULT NULL,
+  postcode varchar(16) DEFAULT NULL,
+  PRIMARY KEY (id),
+  KEY lon (lon),
+  KEY lat (lat),
+  SPATIAL KEY geom (geom)
+) ENGINE=InnoDB DEFAULT CHARSET='utf8mb4';`},
+		Down: []string{"DROP TABLE %[1]s;"},
+	},
+	{
+		Version: 4,
+		Name:    "create_distance_loc",
+		// Returns DOUBLE, since DECIMAL(12,8) can't hold the longest
+		// distances in miles. DETERMINISTIC lets it be created with binary
+		// logging on.
+		Up: []string{`CREATE FUNCTION distance_loc (lon DECIMAL(12,8), lat DECIMAL(12,8), tlon DECIMAL(12,8), tlat DECIMAL(12,8))
+	RETURNS DOUBLE DETERMINISTIC NO SQL
+	RETURN 3959 * ACOS( LEAST(1, SIN( lat ) * SIN( tlat) + COS( tlat ) * COS( lat ) * COS( lon - tlon )) );`},
+		Down: []string{"DROP FUNCTION distance_loc;"},
+	},
+	{
+		Version:  5,
+		Name:     "add_geog",
+		PerTable: true,
+		Server:   GeographicVersion,
+		// A copy of geom in the geographic SRS, for distances on the
+		// ellipsoid. A column with a SPATIAL KEY must be NOT NULL, so it is
+		// filled before the constraint and index are added.
+		Up: []string{
+			"ALTER TABLE %[1]s ADD COLUMN geog POINT SRID 4326;",
+			"UPDATE %[1]s SET geog = ST_SRID(geom, 4326);",
+			"ALTER TABLE %[1]s MODIFY COLUMN geog POINT NOT NULL SRID 4326, ADD SPATIAL KEY geog (geog);",
+		},
+		Down: []string{"ALTER TABLE %[1]s DROP COLUMN geog;"},
+	},
+	{
+		Version: 6,
+		Name:    "distance_loc_radius",
+		// Takes the radius of the sphere, so that the stored strategy uses
+		// the same Earth model as the others.
+		Up: []string{
+			"DROP FUNCTION distance_loc;",
+			`CREATE FUNCTION distance_loc (lon DECIMAL(12,8), lat DECIMAL(12,8), tlon DECIMAL(12,8), tlat DECIMAL(12,8), radius DOUBLE)
+	RETURNS DOUBLE DETERMINISTIC NO SQL
+	RETURN radius * ACOS( LEAST(1, SIN( lat ) * SIN( tlat) + COS( tlat ) * COS( lat ) * COS( lon - tlon )) );`,
+		},
+		Down: []string{
+			"DROP FUNCTION distance_loc;",
+			`CREATE FUNCTION distance_loc (lon DECIMAL(12,8), lat DECIMAL(12,8), tlon DECIMAL(12,8), tlat DECIMAL(12,8))
+	RETURNS DOUBLE DETERMINISTIC NO SQL
+	RETURN 3959 * ACOS( LEAST(1, SIN( lat ) * SIN( tlat) + COS( tlat ) * COS( lat ) * COS( lon - tlon )) );`,
+		},
+	},
+	{
+		Version:  7,
+		Name:     "add_geohash",
+		PerTable: true,
+		// Filled by load --geohash. Geohashes are ASCII and case-sensitive,
+		// and the index serves searches by prefix as range scans.
+		Up: []string{
+			"ALTER TABLE %[1]s ADD COLUMN geohash varchar(12) CHARACTER SET ascii COLLATE ascii_bin DEFAULT NULL, ADD KEY geohash (geohash);",
+		},
+		Down: []string{"ALTER TABLE %[1]s DROP COLUMN geohash;"},
+	},
+	{
+		Version:  8,
+		Name:     "add_s2cell",
+		PerTable: true,
+		// Filled by load --s2cell with the leaf cell ID, as a signed
+		// BIGINT. The index serves searches by cell range as range scans.
+		Up: []string{
+			"ALTER TABLE %[1]s ADD COLUMN s2cell BIGINT DEFAULT NULL, ADD KEY s2cell (s2cell);",
+		},
+		Down: []string{"ALTER TABLE %[1]s DROP COLUMN s2cell;"},
+	},
+}
+
+// CreateMigrationsTable creates the table that records the migrations
+// applied.
+var CreateMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  scope varchar(64) NOT NULL COMMENT 'address table for per-table migrations, or empty',
+  version int NOT NULL,
+  name varchar(64) NOT NULL,
+  applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (scope, version)
+) ENGINE=InnoDB;`
+
+// validIdentifier matches the schema names init will create.
+var validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_$]{1,64}$`)
+
+// Migrator applies and rolls back Migrations for one schema and address
+// table.
+type Migrator struct {
+	DB     *sql.DB
+	Table  string
+	Server ServerInfo
+}
+
+// NewMigrator returns a Migrator for table, detecting the server's version.
+func NewMigrator(table string, db *sql.DB) (*Migrator, error) {
+	server, err := GetServerInfo(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Table: table, Server: server}, nil
+}
+
+// supported reports whether the server can run migration.
+func (m *Migrator) supported(migration *Migration) bool {
+	return migration.Server == "" || m.Server.AtLeast(migration.Server)
+}
+
+// Applied returns the time each applicable migration was applied, by
+// version, in UTC.
+func (m *Migrator) Applied() (map[int]time.Time, error) {
+	if _, err := m.DB.Exec(CreateMigrationsTable); err != nil {
+		return nil, err
+	}
+	// UNIX_TIMESTAMP reads the time whatever the session's time zone.
+	rows, err := m.DB.Query("SELECT version, UNIX_TIMESTAMP(applied_at) FROM schema_migrations WHERE scope IN ('', ?);", m.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0).UTC()
+	}
+	return applied, rows.Err()
+}
+
+// run executes the statements of a migration in one direction.
+func (m *Migrator) run(migration *Migration, statements []string) error {
+	for _, statement := range statements {
+		if migration.PerTable {
+			statement = fmt.Sprintf(statement, QuoteTable(m.Table))
+		}
+		if _, err := m.DB.Exec(statement); err != nil {
+			return fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// Up applies every migration not yet applied, in version order, and returns
+// the number applied. DDL is not transactional in MySQL, so a migration that
+// fails part way may need cleaning up by hand.
+func (m *Migrator) Up() (int, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range Migrations {
+		migration := &Migrations[i]
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if !m.supported(migration) {
+			fmt.Printf("Skipping %d %s: %s\n", migration.Version, migration.Name, Yellow("needs MySQL %s", migration.Server))
+			continue
+		}
+		fmt.Printf("Applying %d %s\n", migration.Version, Cyan("%s", migration.Name))
+		if err := m.run(migration, migration.Up); err != nil {
+			return count, err
+		}
+		if _, err := m.DB.Exec("INSERT INTO schema_migrations (scope, version, name) VALUES (?, ?, ?);",
+			migration.scope(m.Table), migration.Version, migration.Name); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Rollback reverts the latest steps applied migrations of the address table,
+// newest first, and returns the number reverted. The shared migrations are
+// reverted after those of the table, and only if no other address table has
+// migrations applied, since those tables rely on them.
+func (m *Migrator) Rollback(steps int) (int, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return 0, err
+	}
+	var others int
+	if err := m.DB.QueryRow("SELECT COUNT(DISTINCT scope) FROM schema_migrations WHERE scope NOT IN ('', ?);", m.Table).Scan(&others); err != nil {
+		return 0, err
+	}
+	count := 0
+	revert := func(perTable bool) error {
+		for i := len(Migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := &Migrations[i]
+			if _, ok := applied[migration.Version]; !ok || migration.PerTable != perTable {
+				continue
+			}
+			fmt.Printf("Rolling back %d %s\n", migration.Version, Cyan("%s", migration.Name))
+			if err := m.run(migration, migration.Down); err != nil {
+				return err
+			}
+			if _, err := m.DB.Exec("DELETE FROM schema_migrations WHERE scope = ? AND version = ?;",
+				migration.scope(m.Table), migration.Version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}
+	if err := revert(true); err != nil {
+		return count, err
+	}
+	if count < steps {
+		if others > 0 {
+			fmt.Printf("Keeping the shared migrations, used by %s other address tables\n", Yellow("%d", others))
+		} else if err := revert(false); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Init creates the schema if it does not exist, then applies the migrations
+func (gc *GeoCommand) Init(ctx *kingpin.ParseContext) error {
+	if !validIdentifier.MatchString(gc.Schema) {
+		return fmt.Errorf("invalid schema name %q", gc.Schema)
+	}
+	server, err := sql.Open("mysql", gc.dataSourceName(""))
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	if _, err := server.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` DEFAULT CHARACTER SET utf8mb4;", gc.Schema)); err != nil {
+		return err
+	}
+	return gc.MigrateUp(ctx)
+}
+
+// MigrateUp applies the migrations not yet applied
+func (gc *GeoCommand) MigrateUp(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+	migrator, err := NewMigrator(gc.Table, db)
+	if err != nil {
+		return err
+	}
+	count, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Printf("%s.%s is up to date\n", gc.Schema, gc.Table)
+		return nil
+	}
+	fmt.Printf("Applied %v migrations to %s.%s\n", Green("%d", count), gc.Schema, gc.Table)
+	return nil
+}
+
+// MigrateRollback reverts the latest migrations
+func (gc *GeoCommand) MigrateRollback(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+	migrator, err := NewMigrator(gc.Table, db)
+	if err != nil {
+		return err
+	}
+	count, err := migrator.Rollback(gc.Steps)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rolled back %v migrations from %s.%s\n", Yellow("%d", count), gc.Schema, gc.Table)
+	return nil
+}
+
+// MigrateStatus lists the migrations and when each was applied
+func (gc *GeoCommand) MigrateStatus(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+	migrator, err := NewMigrator(gc.Table, db)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Applied()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "version\tname\tapplied")
+	for _, migration := range Migrations {
+		status := Yellow("pending")
+		if t, ok := applied[migration.Version]; ok {
+			status = Green("%s", t.Format(time.RFC3339))
+		} else if !migrator.supported(&migration) {
+			status = Yellow("needs MySQL %s", migration.Server)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Version, migration.Name, status)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// nearestStartRadius is the first radius a k-nearest search tries when it
+// is given none.
+const nearestStartRadius = Kilometer
+
+// NearestSearch describes a search for the K rows nearest to a point.
+type NearestSearch struct {
+	K         int     // Number of rows to return
+	Unit      string  // Unit of the radii and of the distances returned, one of LengthUnits. Defaults to km
+	Earth     string  // Earth model the distances are measured on, one of EarthModels. Defaults to mean
+	Radius    float64 // First radius searched. 0 starts from nearestStartRadius
+	MaxRadius float64 // Rows beyond it are never returned. 0 for no limit
+}
+
+// NearestResult is what a k-nearest search found, and how.
+type NearestResult struct {
+	Matches   []Match       // the rows found, nearest first
+	Radii     []float64     // the radius of each search run; 0 is unbounded
+	QueryTime time.Duration // summed over the searches
+	FetchTime time.Duration // summed over the searches
+}
+
+// indexedStrategy is implemented by strategies whose radius searches are
+// restricted by an index, so that a small radius costs less than a large
+// one.
+type indexedStrategy interface {
+	Indexed() bool
+}
+
+// FindNearest finds the n.K rows of table nearest to p with s. Strategies
+// backed by an index search an expanding radius: each search asks for the
+// nearest n.K rows within it, and once it finds them all, no row outside it
+// can be nearer. Otherwise the radius grows, by how far short the search
+// fell, until it spans the Earth or reaches n.MaxRadius. Strategies without
+// an index scan the table once.
+func FindNearest(s QueryStrategy, table string, p Point, n NearestSearch, db *sql.DB) (*NearestResult, error) {
+	if n.K < 1 {
+		return nil, fmt.Errorf("a nearest search needs k of at least 1, not %d", n.K)
+	}
+	unit, earth, err := Search{Unit: n.Unit, Earth: n.Earth}.Model()
+	if err != nil {
+		return nil, err
+	}
+	// No two points are further apart than half way round the Earth.
+	limit := earth.Sphere(math.Pi).In(unit)
+	if n.MaxRadius > 0 && n.MaxRadius < limit {
+		limit = n.MaxRadius
+	}
+	radius := n.Radius
+	if radius <= 0 {
+		radius = nearestStartRadius.In(unit)
+	}
+	if indexed, ok := s.(indexedStrategy); !ok || !indexed.Indexed() {
+		radius = limit
+	}
+
+	result := &NearestResult{}
+	for {
+		final := radius >= limit
+		if final {
+			radius = n.MaxRadius
+		}
+		search := Search{Radius: radius, Unit: n.Unit, Earth: n.Earth, Limit: n.K, Order: "distance"}
+		query, args := s.SQL(table, p, search)
+		var matches []Match
+		queryTime, fetchTime, _, err := TimeQuery(s, query, args, db, func(m Match) {
+			matches = append(matches, m)
+		})
+		result.QueryTime += queryTime
+		result.FetchTime += fetchTime
+		result.Radii = append(result.Radii, radius)
+		if err != nil {
+			return result, err
+		}
+		if final || len(matches) >= n.K {
+			result.Matches = matches
+			return result, nil
+		}
+
+		// Rows are spread over an area, which grows with the square of
+		// the radius. Aim for twice the rows still needed.
+		grow := 4.0
+		if len(matches) > 0 {
+			grow = math.Max(2, math.Sqrt(2*float64(n.K)/float64(len(matches))))
+		}
+		radius *= grow
+	}
+}
+
+// CheckNearest compares the rows found by a k-nearest search with the k
+// nearest in index, which answers the same search in memory. It returns how
+// many of the rows found are among those in index, and the largest
+// difference between the distances of the rows of each rank.
+func CheckNearest(matches []Match, index SpatialIndex, p Point, n NearestSearch) (agree int, maxDiff float64, err error) {
+	reference, err := SearchIndex(index, p, Search{Radius: n.MaxRadius, Unit: n.Unit, Earth: n.Earth, Limit: n.K, Order: "distance"})
+	if err != nil {
+		return 0, 0, err
+	}
+	ids := make(map[string]bool)
+	for _, m := range reference {
+		ids[m.ID] = true
+	}
+	for i, m := range matches {
+		if ids[m.ID] {
+			agree++
+		}
+		if i < len(reference) {
+			maxDiff = math.Max(maxDiff, math.Abs(m.Distance.Float64-reference[i].Distance.Float64))
+		}
+	}
+	if len(matches) != len(reference) {
+		err = fmt.Errorf("found %d rows; the reference found %d", len(matches), len(reference))
+	}
+	return agree, maxDiff, err
+}
+
+// Nearest finds the rows of the address table nearest to the lon/lat
+// provided on the command line.
+func (gc *GeoCommand) Nearest(context *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+
+	strategy, err := LookupStrategy(gc.QueryType)
+	if err != nil {
+		return err
+	}
+	if err := CheckRequirements(strategy, gc.Schema, gc.Table, db); err != nil {
+		return err
+	}
+	anchor, err := ParsePoint(gc.Lon + "," + gc.Lat)
+	if err != nil {
+		return err
+	}
+	// The rows found come nearest first.
+	gc.Order = "distance"
+	results, err := gc.NewResults("nearest", db)
+	if err != nil {
+		return err
+	}
+
+	n := NearestSearch{K: gc.Limit, Unit: gc.Unit, Earth: gc.Earth, Radius: gc.StartRadius, MaxRadius: gc.Radius}
+	result, err := FindNearest(strategy, gc.Table, anchor, n, db)
+	if err != nil {
+		return err
+	}
+	if !gc.Quiet {
+		for _, m := range result.Matches {
+			fmt.Fprintf(os.Stdout, "%s - %f\n", m.ID, m.Distance.Float64)
+		}
+	}
+	radii := make([]string, len(result.Radii))
+	for i, r := range result.Radii {
+		radii[i] = formatCoord(Round(r, 3))
+		if r == 0 {
+			radii[i] = "unbounded"
+		}
+	}
+	fmt.Printf("Found %v rows in %d searches, of radius %s %s\n", Green("%d", len(result.Matches)), len(result.Radii), strings.Join(radii, ", "), gc.Unit)
+	fmt.Printf("Query time: %s  Fetch time: %s\n", result.QueryTime, result.FetchTime)
+
+	if gc.Check {
+		points, err := TablePoints(gc.Table, db)
+		if err != nil {
+			return err
+		}
+		index := NewKDTree(points)
+		agree, maxDiff, err := CheckNearest(result.Matches, index, anchor, n)
+		color := Green
+		if err != nil || agree < len(result.Matches) {
+			color = Yellow
+		}
+		fmt.Printf("Reference k-d tree: %v of the rows agree, largest distance difference %g %s\n",
+			color("%d/%d", agree, len(result.Matches)), maxDiff, gc.Unit)
+		if err != nil {
+			fmt.Println(Yellow("%v", err))
+		}
+	}
+
+	results.Add(Measurement{
+		QueryType: gc.QueryType,
+		Anchor:    anchor,
+		Iteration: 1,
+		QueryTime: result.QueryTime,
+		FetchTime: result.FetchTime,
+		Rows:      len(result.Matches),
+	})
+	if gc.ResultsFile != "" {
+		return results.Write(gc.ResultsFile)
+	}
+	return nil
+}
+
+// clusterMembers converts the rows found by a search into cluster members.
+func clusterMembers(matches []Match) ([]ClusterMember, error) {
+	cluster := make([]ClusterMember, len(matches))
+	for i, m := range matches {
+		id, err := strconv.Atoi(m.ID)
+		if err != nil {
+			return nil, err
+		}
+		cluster[i] = ClusterMember{id, m.Distance.Float64}
+	}
+	return cluster, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Measurement is a single timed execution of a query.
+type Measurement struct {
+	QueryType string        `json:"query_type"`
+	Variant   string        `json:"variant,omitempty"` // table queried, in a run across variant tables
+	Anchor    Point         `json:"anchor"`
+	Iteration int           `json:"iteration"`
+	QueryTime time.Duration `json:"query_time_ns"`
+	FetchTime time.Duration `json:"fetch_time_ns"`
+	Rows      int           `json:"rows"`
+}
+
+// Key identifies what was measured: the query type, qualified with the
+// variant table if there is one.
+func (m *Measurement) Key() string {
+	if m.Variant == "" {
+		return m.QueryType
+	}
+	return m.QueryType + "@" + m.Variant
+}
+
+// RunMetadata describes what was run, and where.
+type RunMetadata struct {
+	Command       string    `json:"command"`
+	Started       time.Time `json:"started"`
+	Schema        string    `json:"schema"`
+	Table         string    `json:"table"`
+	TableRows     int       `json:"table_rows"`
+	Engine        string    `json:"engine"`
+	ServerVersion string    `json:"server_version"`
+	ServerHost    string    `json:"server_host"`
+	Radius        float64   `json:"radius"`
+	Unit          string    `json:"unit"`
+	Earth         string    `json:"earth,omitempty"`
+	Limit         int       `json:"limit"`
+	Order         string    `json:"order"`
+	Warmup        int       `json:"warmup"`
+	Iterations    int       `json:"iterations"`
+	Hostname      string    `json:"hostname"`
+	GoVersion     string    `json:"go_version"`
+	OS            string    `json:"os"`
+	Arch          string    `json:"arch"`
+	NumCPU        int       `json:"num_cpu"`
+}
+
+// Results is the results model shared by select and benchmark. It can be
+// written as JSON or CSV so that runs can be archived and compared.
+type Results struct {
+	Metadata     RunMetadata   `json:"metadata"`
+	Builds       []IndexBuild  `json:"builds,omitempty"`   // in-memory baselines built, in a benchmark with --memory
+	Failures     []Failure     `json:"failures,omitempty"` // query types that failed on a variant table, in a matrix
+	Measurements []Measurement `json:"measurements"`
+}
+
+// Failure is a query type that failed on a table, and was not measured
+// there.
+type Failure struct {
+	QueryType string `json:"query_type"`
+	Variant   string `json:"variant"`
+	Error     string `json:"error"`
+}
+
+// NewResults creates an empty Results for command, filling in the run
+// metadata from the local host and the MySQL server.
+func (gc *GeoCommand) NewResults(command string, db *sql.DB) (*Results, error) {
+	hostname, _ := os.Hostname()
+	r := &Results{
+		Metadata: RunMetadata{
+			Command:    command,
+			Started:    time.Now().UTC(),
+			Schema:     gc.Schema,
+			Table:      gc.Table,
+			Radius:     gc.Radius,
+			Unit:       gc.Unit,
+			Earth:      gc.Earth,
+			Limit:      gc.Limit,
+			Order:      gc.Order,
+			Warmup:     gc.Warmup,
+			Iterations: gc.Iterations,
+			Hostname:   hostname,
+			GoVersion:  runtime.Version(),
+			OS:         runtime.GOOS,
+			Arch:       runtime.GOARCH,
+			NumCPU:     runtime.NumCPU(),
+		},
+	}
+
+	m := &r.Metadata
+	err := db.QueryRow("SELECT VERSION(), @@hostname;").Scan(&m.ServerVersion, &m.ServerHost)
+	if err != nil {
+		return nil, err
+	}
+	var tableRows sql.NullInt64
+	var engine sql.NullString
+	err = db.QueryRow("SELECT ENGINE, TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?;", gc.Schema, gc.Table).Scan(&engine, &tableRows)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	m.Engine = engine.String
+	m.TableRows = int(tableRows.Int64)
+	return r, nil
+}
+
+// Add appends a measurement to the results.
+func (r *Results) Add(m Measurement) {
+	r.Measurements = append(r.Measurements, m)
+}
+
+// QueryTypes returns the keys of the query types measured, in the order
+// first seen. See Measurement.Key.
+func (r *Results) QueryTypes() []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, m := range r.Measurements {
+		if !seen[m.Key()] {
+			seen[m.Key()] = true
+			types = append(types, m.Key())
+		}
+	}
+	return types
+}
+
+// Timings returns the query times, fetch times and total rows measured for
+// the query type key.
+func (r *Results) Timings(queryType string) (queryTimes, fetchTimes []time.Duration, rows int) {
+	for _, m := range r.Measurements {
+		if m.Key() != queryType {
+			continue
+		}
+		queryTimes = append(queryTimes, m.QueryTime)
+		fetchTimes = append(fetchTimes, m.FetchTime)
+		rows += m.Rows
+	}
+	return
+}
+
+// Write saves the results to path. The format is chosen by the file
+// extension, which must be .json or .csv.
+func (r *Results) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(path) {
+	case ".json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	case ".csv":
+		err = r.writeCSV(f)
+	default:
+		err = fmt.Errorf("results file %s must end in .json or .csv", path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// resultsCSVHeader lists the CSV columns. Every row repeats the run metadata
+// so that each row stands on its own when loaded into a plotting tool.
+var resultsCSVHeader = []string{
+	"command", "started", "hostname", "server_host", "server_version",
+	"schema", "table", "table_rows", "engine", "radius", "unit",
+	"query_type", "anchor_lon", "anchor_lat", "iteration",
+	"query_time_ns", "fetch_time_ns", "rows", "variant", "earth",
+	"limit", "order",
+}
+
+// optionalResultsColumns are the columns added since results were first
+// written, which older files lack.
+var optionalResultsColumns = map[string]bool{
+	"variant": true, "earth": true, "limit": true, "order": true,
+}
+
+func (r *Results) writeCSV(f *os.File) error {
+	w := csv.NewWriter(f)
+	if err := w.Write(resultsCSVHeader); err != nil {
+		return err
+	}
+	md := r.Metadata
+	for _, m := range r.Measurements {
+		err := w.Write([]string{
+			md.Command,
+			md.Started.Format(time.RFC3339),
+			md.Hostname,
+			md.ServerHost,
+			md.ServerVersion,
+			md.Schema,
+			md.Table,
+			strconv.Itoa(md.TableRows),
+			md.Engine,
+			formatCoord(md.Radius),
+			md.Unit,
+			m.QueryType,
+			formatCoord(m.Anchor.Lon),
+			formatCoord(m.Anchor.Lat),
+			strconv.Itoa(m.Iteration),
+			strconv.FormatInt(int64(m.QueryTime), 10),
+			strconv.FormatInt(int64(m.FetchTime), 10),
+			strconv.Itoa(m.Rows),
+			m.Variant,
+			md.Earth,
+			strconv.Itoa(md.Limit),
+			md.Order,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReadResults loads results written by Results.Write. The format is chosen by
+// the file extension.
+func ReadResults(path string) (*Results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &Results{}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(r)
+	case ".csv":
+		err = r.readCSV(f)
+	default:
+		err = fmt.Errorf("results file %s must end in .json or .csv", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *Results) readCSV(f *os.File) error {
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no header row")
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	for _, name := range resultsCSVHeader {
+		if _, ok := col[name]; !ok && !optionalResultsColumns[name] {
+			return fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	for i, row := range rows[1:] {
+		get := func(name string) string {
+			if c, ok := col[name]; ok {
+				return row[c]
+			}
+			return ""
+		}
+		ints := make(map[string]int64)
+		for _, name := range []string{"table_rows", "iteration", "query_time_ns", "fetch_time_ns", "rows"} {
+			v, err := strconv.ParseInt(get(name), 10, 64)
+			if err != nil {
+				return fmt.Errorf("row %d: %s: %v", i+2, name, err)
+			}
+			ints[name] = v
+		}
+		anchor, err := ParsePoint(get("anchor_lon") + "," + get("anchor_lat"))
+		if err != nil {
+			return fmt.Errorf("row %d: %v", i+2, err)
+		}
+		if i == 0 {
+			radius, err := strconv.ParseFloat(get("radius"), 64)
+			if err != nil {
+				return fmt.Errorf("row %d: radius: %v", i+2, err)
+			}
+			started, err := time.Parse(time.RFC3339, get("started"))
+			if err != nil {
+				return fmt.Errorf("row %d: started: %v", i+2, err)
+			}
+			limit := 0
+			if s := get("limit"); s != "" {
+				if limit, err = strconv.Atoi(s); err != nil {
+					return fmt.Errorf("row %d: limit: %v", i+2, err)
+				}
+			}
+			r.Metadata = RunMetadata{
+				Command:       get("command"),
+				Started:       started,
+				Hostname:      get("hostname"),
+				ServerHost:    get("server_host"),
+				ServerVersion: get("server_version"),
+				Schema:        get("schema"),
+				Table:         get("table"),
+				TableRows:     int(ints["table_rows"]),
+				Engine:        get("engine"),
+				Radius:        radius,
+				Unit:          get("unit"),
+				Earth:         get("earth"),
+				Limit:         limit,
+				Order:         get("order"),
+			}
+		}
+		r.Add(Measurement{
+			QueryType: get("query_type"),
+			Variant:   get("variant"),
+			Anchor:    anchor,
+			Iteration: int(ints["iteration"]),
+			QueryTime: time.Duration(ints["query_time_ns"]),
+			FetchTime: time.Duration(ints["fetch_time_ns"]),
+			Rows:      int(ints["rows"]),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResultsRoundTrip(t *testing.T) {
+	r := &Results{
+		Metadata: RunMetadata{
+			Command:   "benchmark",
+			Started:   time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+			Schema:    "geo",
+			Table:     "locations",
+			TableRows: 1000,
+			Engine:    "InnoDB",
+			Radius:    2.5,
+			Unit:      "mi",
+			Earth:     "wgs84",
+			Limit:     10,
+			Order:     "distance",
+		},
+	}
+	for i := 0; i < 3; i++ {
+		r.Add(Measurement{
+			QueryType: "bbox",
+			Variant:   "spatial",
+			Anchor:    Point{16.3725, 48.2083},
+			Iteration: i,
+			QueryTime: time.Duration(i+1) * time.Millisecond,
+			FetchTime: time.Duration(i+1) * time.Microsecond,
+			Rows:      i * 4,
+		})
+	}
+
+	dir := t.TempDir()
+	var read []*Results
+	for _, name := range []string{"results.json", "results.csv"} {
+		path := filepath.Join(dir, name)
+		if err := r.Write(path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadResults(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Measurements, r.Measurements) {
+			t.Errorf("%s holds measurements %v, want %v", name, got.Measurements, r.Measurements)
+		}
+		read = append(read, got)
+	}
+
+	// A JSON baseline compares with the CSV of the same run.
+	if mismatches := MetadataMismatches(read[0].Metadata, read[1].Metadata); len(mismatches) > 0 {
+		t.Errorf("the JSON and CSV results of one run differ in %v", mismatches)
+	}
+
+	// CSV files written before the limit and order were match any.
+	old := read[1].Metadata
+	old.Limit, old.Order = 0, ""
+	if mismatches := MetadataMismatches(read[0].Metadata, old); len(mismatches) > 0 {
+		t.Errorf("results without a limit and order differ in %v", mismatches)
+	}
+	old.Radius = 5
+	if mismatches := MetadataMismatches(read[0].Metadata, old); len(mismatches) != 1 {
+		t.Errorf("results of another radius differ in %v", mismatches)
+	}
+
+	// A nearest search differs from a radius search of the same parameters,
+	// which select and benchmark both run.
+	selected, nearest := read[0].Metadata, read[0].Metadata
+	selected.Command, nearest.Command = "select", "nearest"
+	if mismatches := MetadataMismatches(read[0].Metadata, selected); len(mismatches) > 0 {
+		t.Errorf("select and benchmark results differ in %v", mismatches)
+	}
+	if mismatches := MetadataMismatches(selected, nearest); len(mismatches) != 1 {
+		t.Errorf("select and nearest results differ in %v", mismatches)
+	}
+}
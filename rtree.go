@@ -0,0 +1,233 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// rtreeNodeSize is the number of entries in each node of an RTree.
+const rtreeNodeSize = 16
+
+// RTree is a static R-tree of points over longitude and latitude, bulk
+// loaded with the sort-tile-recursive algorithm. The points are sorted into
+// vertical slices by longitude, and each slice by latitude; runs of
+// rtreeNodeSize become the leaves. The leaves are packed into the level above
+// the same way, by their centers, and so on up to the root.
+type RTree struct {
+	root *rtreeNode
+	size int
+}
+
+// rtreeNode is a node of an RTree: a leaf holding points, or a branch
+// holding nodes, and the box containing them all.
+type rtreeNode struct {
+	box      Box
+	children []*rtreeNode
+	points   []IndexedPoint
+}
+
+// strOrder sorts n entries into sort-tile-recursive order, with sortBy,
+// which sorts the entries from lo to hi by longitude, or by latitude.
+func strOrder(n int, sortBy func(lo, hi int, byLat bool)) {
+	nodes := (n + rtreeNodeSize - 1) / rtreeNodeSize
+	slice := int(math.Ceil(math.Sqrt(float64(nodes)))) * rtreeNodeSize
+	sortBy(0, n, false)
+	for lo := 0; lo < n; lo += slice {
+		hi := lo + slice
+		if hi > n {
+			hi = n
+		}
+		sortBy(lo, hi, true)
+	}
+}
+
+// NewRTree builds an RTree of points.
+func NewRTree(points []IndexedPoint) *RTree {
+	t := &RTree{size: len(points)}
+	if len(points) == 0 {
+		return t
+	}
+
+	sorted := append([]IndexedPoint(nil), points...)
+	strOrder(len(sorted), func(lo, hi int, byLat bool) {
+		s := sorted[lo:hi]
+		sort.Slice(s, func(i, j int) bool {
+			if byLat {
+				return s[i].Lat < s[j].Lat
+			}
+			return s[i].Lon < s[j].Lon
+		})
+	})
+	var level []*rtreeNode
+	for lo := 0; lo < len(sorted); lo += rtreeNodeSize {
+		hi := lo + rtreeNodeSize
+		if hi > len(sorted) {
+			hi = len(sorted)
+		}
+		leaf := &rtreeNode{points: sorted[lo:hi:hi]}
+		leaf.box = pointsBox(leaf.points)
+		level = append(level, leaf)
+	}
+
+	for len(level) > 1 {
+		strOrder(len(level), func(lo, hi int, byLat bool) {
+			s := level[lo:hi]
+			sort.Slice(s, func(i, j int) bool {
+				if byLat {
+					return s[i].box.MinLat+s[i].box.MaxLat < s[j].box.MinLat+s[j].box.MaxLat
+				}
+				return s[i].box.MinLon+s[i].box.MaxLon < s[j].box.MinLon+s[j].box.MaxLon
+			})
+		})
+		var parents []*rtreeNode
+		for lo := 0; lo < len(level); lo += rtreeNodeSize {
+			hi := lo + rtreeNodeSize
+			if hi > len(level) {
+				hi = len(level)
+			}
+			parent := &rtreeNode{children: level[lo:hi:hi], box: level[lo].box}
+			for _, child := range parent.children[1:] {
+				parent.box = unionBox(parent.box, child.box)
+			}
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+	t.root = level[0]
+	return t
+}
+
+// pointsBox returns the smallest box containing points.
+func pointsBox(points []IndexedPoint) Box {
+	b := Box{points[0].Lon, points[0].Lat, points[0].Lon, points[0].Lat}
+	for _, p := range points[1:] {
+		b = unionBox(b, Box{p.Lon, p.Lat, p.Lon, p.Lat})
+	}
+	return b
+}
+
+// unionBox returns the smallest box containing a and b.
+func unionBox(a, b Box) Box {
+	return Box{
+		MinLon: math.Min(a.MinLon, b.MinLon),
+		MinLat: math.Min(a.MinLat, b.MinLat),
+		MaxLon: math.Max(a.MaxLon, b.MaxLon),
+		MaxLat: math.Max(a.MaxLat, b.MaxLat),
+	}
+}
+
+// Len returns the number of points in the tree.
+func (t *RTree) Len() int {
+	return t.size
+}
+
+// search calls visit for every point under n that lies in b.
+func (n *rtreeNode) search(b Box, visit func(q IndexedPoint)) {
+	if !boxesOverlap(n.box, b) {
+		return
+	}
+	for _, q := range n.points {
+		if b.Contains(q.Point) {
+			visit(q)
+		}
+	}
+	for _, child := range n.children {
+		child.search(b, visit)
+	}
+}
+
+// Within returns the points within radius kilometers of p. The tree is
+// searched with the circle's bounding boxes, which are disjoint, and the
+// points found are kept if they are within the circle.
+func (t *RTree) Within(p Point, radius float64) []Match {
+	var matches []Match
+	if t.root == nil {
+		return matches
+	}
+	for _, b := range BoundingBoxes(p, radius) {
+		t.root.search(b, func(q IndexedPoint) {
+			if d := Distance2(p.Lon, p.Lat, q.Lon, q.Lat); d <= radius {
+				matches = append(matches, indexMatch(q, d))
+			}
+		})
+	}
+	return matches
+}
+
+// rtreeEntry is a node or a point waiting in the queue of a nearest
+// neighbor search, with its distance from the point searched for: the exact
+// distance of a point, and no more than that of any point under a node.
+type rtreeEntry struct {
+	node     *rtreeNode // nil for a point
+	point    IndexedPoint
+	distance float64
+}
+
+// rtreeQueue is a priority queue of entries, nearest first.
+type rtreeQueue []rtreeEntry
+
+func (q rtreeQueue) Len() int            { return len(q) }
+func (q rtreeQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q rtreeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *rtreeQueue) Push(x interface{}) { *q = append(*q, x.(rtreeEntry)) }
+func (q *rtreeQueue) Pop() (last interface{}) {
+	last, *q = (*q)[len(*q)-1], (*q)[:len(*q)-1]
+	return last
+}
+
+// Nearest returns the k points nearest to p. It searches best first: the
+// nearest entry is taken from a queue of nodes and points, and a node is
+// replaced by its contents. Once a point is taken, nothing left in the queue
+// is nearer.
+func (t *RTree) Nearest(p Point, k int) []Match {
+	var matches []Match
+	if t.root == nil {
+		return matches
+	}
+	queue := &rtreeQueue{{node: t.root, distance: boxDistance(p, t.root.box)}}
+	for queue.Len() > 0 && len(matches) < k {
+		e := heap.Pop(queue).(rtreeEntry)
+		if e.node == nil {
+			matches = append(matches, indexMatch(e.point, e.distance))
+			continue
+		}
+		for _, q := range e.node.points {
+			heap.Push(queue, rtreeEntry{point: q, distance: Distance2(p.Lon, p.Lat, q.Lon, q.Lat)})
+		}
+		for _, child := range e.node.children {
+			heap.Push(queue, rtreeEntry{node: child, distance: boxDistance(p, child.box)})
+		}
+	}
+	return matches
+}
+
+// boxDistance returns the distance in kilometers from p to the nearest point
+// of b. Along any parallel the distance from p grows with the difference in
+// longitude, so the nearest point lies on p's meridian if the box spans it,
+// and on one of the box's meridians otherwise.
+func boxDistance(p Point, b Box) float64 {
+	if b.Contains(p) {
+		return 0
+	}
+	if p.Lon >= b.MinLon && p.Lon <= b.MaxLon {
+		lat := math.Max(b.MinLat, math.Min(b.MaxLat, p.Lat))
+		return Distance2(p.Lon, p.Lat, p.Lon, lat)
+	}
+	return math.Min(meridianDistance(p, b.MinLon, b.MinLat, b.MaxLat), meridianDistance(p, b.MaxLon, b.MinLat, b.MaxLat))
+}
+
+// meridianDistance returns the distance in kilometers from p to the nearest
+// point of the meridian lon between latitudes minLat and maxLat. Within 90
+// degrees of longitude, the distance is least where the great circle through
+// p crosses the meridian at right angles, and grows away from it. Further
+// away it is least toward one of the poles.
+func meridianDistance(p Point, lon, minLat, maxLat float64) float64 {
+	cosLon := math.Cos(rad(lon - p.Lon))
+	if cosLon <= 0 {
+		return math.Min(Distance2(p.Lon, p.Lat, lon, minLat), Distance2(p.Lon, p.Lat, lon, maxLat))
+	}
+	lat := deg(math.Atan(math.Tan(rad(p.Lat)) / cosLon))
+	lat = math.Max(minLat, math.Min(maxLat, lat))
+	return Distance2(p.Lon, p.Lat, lon, lat)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CellID identifies a cell of a hierarchical decomposition of the sphere
+// modelled on Google's S2. The sphere is projected onto the six faces of a
+// cube, and each face is divided recursively into four, 30 times over. The
+// cells of each level are numbered along a Hilbert curve, so cells close in
+// number are close on the sphere, and every cell's descendants are a
+// contiguous range of leaf cell IDs.
+//
+// The top three bits are the face. They are followed by two bits per level
+// giving the child's position along the curve, then a single 1 bit marking
+// the level, and zeros.
+type CellID uint64
+
+const (
+	// CellMaxLevel is the level of the leaf cells, which are about a
+	// centimeter across.
+	CellMaxLevel = 30
+	cellFaceBits = 3
+	cellPosBits  = 2*CellMaxLevel + 1
+	cellMaxSize  = 1 << CellMaxLevel // leaf cells along each side of a face
+)
+
+// Orientations of the Hilbert curve in a cell: whether its i and j axes are
+// swapped, and whether it runs backwards.
+const (
+	cellSwapMask   = 1
+	cellInvertMask = 2
+)
+
+// cellIJToPos maps the orientation and the child's (i, j) bits to its
+// position along the curve; cellPosToIJ inverts it. cellPosToOrientation
+// gives the change of orientation in the child at each position.
+var (
+	cellIJToPos          = [4][4]uint64{{0, 1, 3, 2}, {0, 3, 1, 2}, {2, 3, 1, 0}, {2, 1, 3, 0}}
+	cellPosToIJ          = [4][4]int{{0, 1, 3, 2}, {0, 2, 3, 1}, {3, 2, 0, 1}, {3, 1, 0, 2}}
+	cellPosToOrientation = [4]int{cellSwapMask, 0, 0, cellInvertMask | cellSwapMask}
+)
+
+// vector is a point in three dimensions.
+type vector struct{ x, y, z float64 }
+
+// pointVector returns the unit vector of p.
+func pointVector(p Point) vector {
+	sinLat, cosLat := math.Sincos(rad(p.Lat))
+	sinLon, cosLon := math.Sincos(rad(p.Lon))
+	return vector{cosLat * cosLon, cosLat * sinLon, sinLat}
+}
+
+// point returns the longitude and latitude of the direction of v.
+func (v vector) point() Point {
+	return Point{deg(math.Atan2(v.y, v.x)), deg(math.Atan2(v.z, math.Hypot(v.x, v.y)))}
+}
+
+// angle returns the angle in radians between v and w.
+func (v vector) angle(w vector) float64 {
+	cross := vector{v.y*w.z - v.z*w.y, v.z*w.x - v.x*w.z, v.x*w.y - v.y*w.x}
+	return math.Atan2(math.Sqrt(cross.x*cross.x+cross.y*cross.y+cross.z*cross.z), v.x*w.x+v.y*w.y+v.z*w.z)
+}
+
+// faceUV projects v onto the cube face it points at, returning the face and
+// the coordinates on it, each in [-1, 1].
+func faceUV(v vector) (face int, u, w float64) {
+	ax, ay, az := math.Abs(v.x), math.Abs(v.y), math.Abs(v.z)
+	switch {
+	case ax >= ay && ax >= az:
+		face = 0
+		if v.x < 0 {
+			face = 3
+		}
+	case ay >= az:
+		face = 1
+		if v.y < 0 {
+			face = 4
+		}
+	default:
+		face = 2
+		if v.z < 0 {
+			face = 5
+		}
+	}
+	switch face {
+	case 0:
+		return face, v.y / v.x, v.z / v.x
+	case 1:
+		return face, -v.x / v.y, v.z / v.y
+	case 2:
+		return face, -v.x / v.z, -v.y / v.z
+	case 3:
+		return face, v.z / v.x, v.y / v.x
+	case 4:
+		return face, v.z / v.y, -v.x / v.y
+	}
+	return face, -v.y / v.z, -v.x / v.z
+}
+
+// faceVector returns the (not unit) vector of the point at u, v on face.
+func faceVector(face int, u, v float64) vector {
+	switch face {
+	case 0:
+		return vector{1, u, v}
+	case 1:
+		return vector{-u, 1, v}
+	case 2:
+		return vector{-u, -v, 1}
+	case 3:
+		return vector{-1, -v, -u}
+	case 4:
+		return vector{v, -1, -u}
+	}
+	return vector{v, u, -1}
+}
+
+// stToUV and uvToST convert between the cube face coordinates u, v and the
+// cell coordinates s, t in [0, 1]. The quadratic transform evens out the
+// areas of the cells, which would otherwise grow toward the face centers.
+func stToUV(s float64) float64 {
+	if s >= 0.5 {
+		return (4*s*s - 1) / 3
+	}
+	return (1 - 4*(1-s)*(1-s)) / 3
+}
+
+func uvToST(u float64) float64 {
+	if u >= 0 {
+		return 0.5 * math.Sqrt(1+3*u)
+	}
+	return 1 - 0.5*math.Sqrt(1-3*u)
+}
+
+// stToIJ returns the leaf cell coordinate of s.
+func stToIJ(s float64) int {
+	return int(math.Max(0, math.Min(cellMaxSize-1, math.Floor(cellMaxSize*s))))
+}
+
+// cellIDFromFaceIJ returns the leaf cell at i, j on face.
+func cellIDFromFaceIJ(face, i, j int) CellID {
+	id := uint64(face) << cellPosBits
+	orientation := face & cellSwapMask
+	for level := 1; level <= CellMaxLevel; level++ {
+		bit := uint(CellMaxLevel - level)
+		ij := (i>>bit&1)<<1 | j>>bit&1
+		pos := cellIJToPos[orientation][ij]
+		id |= pos << uint(cellPosBits-2*level)
+		orientation ^= cellPosToOrientation[pos]
+	}
+	return CellID(id | 1)
+}
+
+// CellIDFromPoint returns the leaf cell containing p.
+func CellIDFromPoint(p Point) CellID {
+	face, u, v := faceUV(pointVector(p))
+	return cellIDFromFaceIJ(face, stToIJ(uvToST(u)), stToIJ(uvToST(v)))
+}
+
+// CellIDFromFace returns the level 0 cell covering face.
+func CellIDFromFace(face int) CellID {
+	return CellID(uint64(face)<<cellPosBits | 1<<(cellPosBits-1))
+}
+
+// Face returns the cube face of the cell, from 0 to 5.
+func (c CellID) Face() int {
+	return int(c >> cellPosBits)
+}
+
+// lsb returns the lowest set bit of the cell, which marks its level.
+func (c CellID) lsb() uint64 {
+	return uint64(c) & -uint64(c)
+}
+
+// cellLSB returns the lowest set bit of the cells of level.
+func cellLSB(level int) uint64 {
+	return 1 << uint(2*(CellMaxLevel-level))
+}
+
+// IsValid reports whether c is a cell: its face exists and its level is
+// marked.
+func (c CellID) IsValid() bool {
+	return c.Face() < 6 && c.lsb()&0x1555555555555555 != 0
+}
+
+// Level returns the level of the cell, from 0 for a face to CellMaxLevel for
+// a leaf.
+func (c CellID) Level() int {
+	return CellMaxLevel - bitsTrailing(c.lsb())/2
+}
+
+// bitsTrailing returns the number of trailing zero bits of a power of two.
+func bitsTrailing(x uint64) int {
+	n := 0
+	for x > 1 {
+		x >>= 1
+		n++
+	}
+	return n
+}
+
+// IsLeaf reports whether c is a leaf cell.
+func (c CellID) IsLeaf() bool {
+	return uint64(c)&1 != 0
+}
+
+// Parent returns the cell of the given level containing c. The level must
+// not be greater than c's.
+func (c CellID) Parent(level int) CellID {
+	lsb := cellLSB(level)
+	return CellID(uint64(c)&-lsb | lsb)
+}
+
+// Children returns the four cells c divides into, in Hilbert curve order.
+// c must not be a leaf.
+func (c CellID) Children() [4]CellID {
+	var children [4]CellID
+	lsb := c.lsb() >> 2
+	child := uint64(c) - c.lsb() + lsb
+	for i := range children {
+		children[i] = CellID(child)
+		child += lsb << 1
+	}
+	return children
+}
+
+// RangeMin and RangeMax return the first and last leaf cells in c.
+func (c CellID) RangeMin() CellID { return CellID(uint64(c) - (c.lsb() - 1)) }
+func (c CellID) RangeMax() CellID { return CellID(uint64(c) + (c.lsb() - 1)) }
+
+// Contains reports whether o is c or one of its descendants.
+func (c CellID) Contains(o CellID) bool {
+	return o >= c.RangeMin() && o <= c.RangeMax()
+}
+
+// faceIJ returns the face of c and the leaf coordinates of its lower left
+// corner.
+func (c CellID) faceIJ() (face, i, j int) {
+	face = c.Face()
+	orientation := face & cellSwapMask
+	for level := 1; level <= c.Level(); level++ {
+		pos := int(uint64(c) >> uint(cellPosBits-2*level) & 3)
+		ij := cellPosToIJ[orientation][pos]
+		bit := uint(CellMaxLevel - level)
+		i |= ij >> 1 << bit
+		j |= ij & 1 << bit
+		orientation ^= cellPosToOrientation[pos]
+	}
+	return face, i, j
+}
+
+// String returns the face and the position at each level, such as 3/0213.
+func (c CellID) String() string {
+	if !c.IsValid() {
+		return fmt.Sprintf("invalid %#x", uint64(c))
+	}
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(c.Face()))
+	b.WriteByte('/')
+	for level := 1; level <= c.Level(); level++ {
+		b.WriteByte(byte('0' + uint64(c)>>uint(cellPosBits-2*level)&3))
+	}
+	return b.String()
+}
+
+// Cell is the region of the sphere covered by a CellID.
+type Cell struct {
+	ID       CellID
+	face     int
+	uv       [2][2]float64 // u and v bounds on the face
+	center   vector        // unit vector of the center
+	vertices [4]vector     // unit vectors of the corners
+}
+
+// NewCell returns the cell of id.
+func NewCell(id CellID) Cell {
+	face, i, j := id.faceIJ()
+	size := 1 << uint(CellMaxLevel-id.Level())
+	c := Cell{ID: id, face: face}
+	for k, ij := range [2]int{i, j} {
+		c.uv[k] = [2]float64{stToUV(float64(ij) / cellMaxSize), stToUV(float64(ij+size) / cellMaxSize)}
+	}
+	c.center = faceVector(face, (c.uv[0][0]+c.uv[0][1])/2, (c.uv[1][0]+c.uv[1][1])/2).unit()
+	for k, corner := range [4][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}} {
+		c.vertices[k] = faceVector(face, c.uv[0][corner[0]], c.uv[1][corner[1]]).unit()
+	}
+	return c
+}
+
+// unit returns v scaled to length 1.
+func (v vector) unit() vector {
+	n := math.Sqrt(v.x*v.x + v.y*v.y + v.z*v.z)
+	return vector{v.x / n, v.y / n, v.z / n}
+}
+
+// Center returns the point at the center of the cell.
+func (c Cell) Center() Point {
+	return c.center.point()
+}
+
+// Vertices returns the corners of the cell, counterclockwise.
+func (c Cell) Vertices() [4]Point {
+	var vertices [4]Point
+	for i, v := range c.vertices {
+		vertices[i] = v.point()
+	}
+	return vertices
+}
+
+// boundRadius returns the angle from the center of the cell to its farthest
+// corner. The edges are great circle arcs, so the corners are its farthest
+// points.
+func (c Cell) boundRadius() float64 {
+	r := 0.0
+	for _, v := range c.vertices {
+		r = math.Max(r, c.center.angle(v))
+	}
+	return r
+}
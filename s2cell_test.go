@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCellIDFromPoint(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		p := randomPoint(rnd)
+		id := CellIDFromPoint(p)
+		if !id.IsValid() || !id.IsLeaf() || id.Level() != CellMaxLevel {
+			t.Fatalf("CellIDFromPoint(%v) = %s, of level %d", p, id, id.Level())
+		}
+		if face, i, j := id.faceIJ(); cellIDFromFaceIJ(face, i, j) != id {
+			t.Fatalf("%s has face %d, i %d, j %d, which give %s", id, face, i, j, cellIDFromFaceIJ(face, i, j))
+		}
+		for level := 0; level < CellMaxLevel; level++ {
+			parent := id.Parent(level)
+			if !parent.IsValid() || parent.Level() != level || parent.Face() != id.Face() {
+				t.Fatalf("Parent(%d) of %s is %s, of level %d", level, id, parent, parent.Level())
+			}
+			if !parent.Contains(id) || parent.RangeMin() > id || parent.RangeMax() < id {
+				t.Fatalf("Parent(%d) of %s, %s, does not contain it", level, id, parent)
+			}
+			children := 0
+			for _, child := range parent.Children() {
+				if child.Level() != level+1 || child.Parent(level) != parent {
+					t.Fatalf("child %s of %s has level %d and parent %s", child, parent, child.Level(), child.Parent(level))
+				}
+				if child.Contains(id) {
+					children++
+				}
+			}
+			if children != 1 {
+				t.Fatalf("%s is in %d children of %s", id, children, parent)
+			}
+			cell := NewCell(parent)
+			if cell.center.angle(pointVector(p)) > cell.boundRadius()+1e-12 {
+				t.Fatalf("%v is outside the bounding circle of %s", p, parent)
+			}
+		}
+	}
+}
+
+func TestCellIDFaceBoundaries(t *testing.T) {
+	tests := []struct {
+		p     Point
+		faces []int // the faces the point may fall on
+	}{
+		{Point{0, 0}, []int{0}},
+		{Point{90, 0}, []int{1}},
+		{Point{0, 90}, []int{2}},
+		{Point{180, 0}, []int{3}},
+		{Point{-180, 0}, []int{3}},
+		{Point{-90, 0}, []int{4}},
+		{Point{0, -90}, []int{5}},
+		{Point{44.999, 0}, []int{0}},
+		{Point{45.001, 0}, []int{1}},
+		{Point{45, 0}, []int{0, 1}},
+		{Point{135, 0}, []int{1, 3}},
+		{Point{-45, 0}, []int{0, 4}},
+		{Point{0, 45}, []int{0, 2}},
+		{Point{0, -45}, []int{0, 5}},
+		{Point{179.999, 44.9}, []int{3}},
+		{Point{-179.999, -44.9}, []int{3}},
+		// A corner of the cube, where three faces meet.
+		{Point{45, 35.26438968}, []int{0, 1, 2}},
+	}
+	for _, test := range tests {
+		id := CellIDFromPoint(test.p)
+		onFace := false
+		for _, face := range test.faces {
+			onFace = onFace || id.Face() == face
+		}
+		if !onFace {
+			t.Errorf("CellIDFromPoint(%v) is on face %d, want one of %v", test.p, id.Face(), test.faces)
+		}
+		cell := NewCell(id)
+		if cell.center.angle(pointVector(test.p)) > cell.boundRadius()+1e-12 {
+			t.Errorf("%v is outside the bounding circle of its cell %s", test.p, id)
+		}
+	}
+}
+
+func TestCellIDHilbertOrder(t *testing.T) {
+	// The cells of a level follow the Hilbert curve across each face, so
+	// each is next to the one before.
+	const level = 6
+	for face := 0; face < 6; face++ {
+		var cells []CellID
+		var walk func(c CellID)
+		walk = func(c CellID) {
+			if c.Level() == level {
+				cells = append(cells, c)
+				return
+			}
+			for _, child := range c.Children() {
+				walk(child)
+			}
+		}
+		walk(CellIDFromFace(face))
+		if len(cells) != 1<<(2*level) {
+			t.Fatalf("face %d has %d cells of level %d", face, len(cells), level)
+		}
+		for k := 1; k < len(cells); k++ {
+			if cells[k] <= cells[k-1] {
+				t.Fatalf("face %d: %s follows %s", face, cells[k], cells[k-1])
+			}
+			_, i0, j0 := cells[k-1].faceIJ()
+			_, i1, j1 := cells[k].faceIJ()
+			if d := abs64(int64(i1-i0)) + abs64(int64(j1-j0)); d != 1<<(CellMaxLevel-level) {
+				t.Fatalf("face %d: %s is not next to %s", face, cells[k], cells[k-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Region is an area of the sphere that a RegionCoverer can cover with cells.
+type Region interface {
+	// ContainsCell reports whether the region certainly contains c.
+	ContainsCell(c Cell) bool
+	// MayIntersectCell reports whether the region may intersect c. It may
+	// return true when it doesn't, but never false when it does.
+	MayIntersectCell(c Cell) bool
+}
+
+// Cap is the circle of points within an angle of a center on the sphere,
+// such as the area of a radius search.
+type Cap struct {
+	center vector
+	angle  float64 // radius in radians
+}
+
+// NewCap returns the cap of points within radius kilometers of center on
+// the sphere of radius Rk.
+func NewCap(center Point, radius float64) Cap {
+	return Cap{pointVector(center), math.Min(math.Pi, radius/Rk)}
+}
+
+// ContainsPoint reports whether p is in the cap.
+func (c Cap) ContainsPoint(p Point) bool {
+	return c.center.angle(pointVector(p)) <= c.angle
+}
+
+// ContainsCell reports whether every corner of the cell is in the cap. A cap
+// no larger than a hemisphere is convex, and then so is the cell within it.
+func (c Cap) ContainsCell(cell Cell) bool {
+	if c.angle >= math.Pi {
+		return true
+	}
+	if c.angle > math.Pi/2 {
+		return false
+	}
+	for _, v := range cell.vertices {
+		if c.center.angle(v) > c.angle {
+			return false
+		}
+	}
+	return true
+}
+
+// MayIntersectCell reports whether the cap overlaps the circle around the
+// cell through its corners.
+func (c Cap) MayIntersectCell(cell Cell) bool {
+	return c.center.angle(cell.center) <= c.angle+cell.boundRadius()+1e-12
+}
+
+// Rect is a latitude/longitude rectangle, which may cross the antimeridian
+// when given as several boxes, as BoundingBoxes returns them.
+type Rect []Box
+
+// cellBoxes returns boxes containing the cell: those of the circle around it
+// through its corners.
+func cellBoxes(cell Cell) []Box {
+	return BoundingBoxes(cell.Center(), (cell.boundRadius()+1e-12)*Rk)
+}
+
+// boxesOverlap reports whether a and b share a point.
+func boxesOverlap(a, b Box) bool {
+	return a.MinLon <= b.MaxLon && b.MinLon <= a.MaxLon && a.MinLat <= b.MaxLat && b.MinLat <= a.MaxLat
+}
+
+// ContainsCell reports whether the boxes around the cell all fall within
+// one of the rectangle's boxes.
+func (r Rect) ContainsCell(cell Cell) bool {
+	for _, c := range cellBoxes(cell) {
+		inside := false
+		for _, b := range r {
+			if c.MinLon >= b.MinLon && c.MaxLon <= b.MaxLon && c.MinLat >= b.MinLat && c.MaxLat <= b.MaxLat {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
+
+// MayIntersectCell reports whether the boxes around the cell overlap the
+// rectangle.
+func (r Rect) MayIntersectCell(cell Cell) bool {
+	for _, c := range cellBoxes(cell) {
+		for _, b := range r {
+			if boxesOverlap(b, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RegionCoverer finds sets of cells that cover regions.
+type RegionCoverer struct {
+	MinLevel int // cells are no larger than this level
+	MaxLevel int // cells are no smaller than this level
+	MaxCells int // the covering stops subdividing once it has this many cells
+}
+
+// DefaultCoverer is the coverer the s2cell query type uses. Each cell of a
+// covering becomes a range scan on the s2cell index.
+var DefaultCoverer = RegionCoverer{MinLevel: 0, MaxLevel: CellMaxLevel, MaxCells: 16}
+
+// Covering returns cells, in increasing order, that together contain r.
+// Cells are subdivided largest first, while the covering would still have
+// no more than MaxCells cells, so the budget goes where it tightens the
+// covering most. Cells below MinLevel are always subdivided, so the
+// covering can exceed MaxCells then.
+func (rc RegionCoverer) Covering(r Region) []CellID {
+	var queue, covering []CellID
+	for face := 0; face < 6; face++ {
+		if id := CellIDFromFace(face); r.MayIntersectCell(NewCell(id)) {
+			queue = append(queue, id)
+		}
+	}
+	// The queue is worked through in order, and children are larger in
+	// level than their parents, so the largest cells are handled first.
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		cell := NewCell(id)
+		if id.Level() >= rc.MaxLevel || (id.Level() >= rc.MinLevel && r.ContainsCell(cell)) {
+			covering = append(covering, id)
+			continue
+		}
+		var children []CellID
+		for _, child := range id.Children() {
+			if r.MayIntersectCell(NewCell(child)) {
+				children = append(children, child)
+			}
+		}
+		if id.Level() >= rc.MinLevel && len(covering)+len(queue)+len(children) > rc.MaxCells {
+			covering = append(covering, id)
+			continue
+		}
+		queue = append(queue, children...)
+	}
+	sort.Slice(covering, func(i, j int) bool { return covering[i] < covering[j] })
+	return covering
+}
+
+// CellRange is the leaf cells from Min to Max, inclusive.
+type CellRange struct {
+	Min, Max CellID
+}
+
+// CellRanges returns the ranges of leaf cells in cells, which must be in
+// increasing order, merging adjacent ones. Ranges never span faces, so that
+// their order is kept when the IDs are stored as signed integers.
+func CellRanges(cells []CellID) []CellRange {
+	var ranges []CellRange
+	for _, c := range cells {
+		r := CellRange{c.RangeMin(), c.RangeMax()}
+		if n := len(ranges); n > 0 && ranges[n-1].Max+2 >= r.Min && ranges[n-1].Max.Face() == r.Min.Face() {
+			if r.Max > ranges[n-1].Max {
+				ranges[n-1].Max = r.Max
+			}
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// CellRangesWhere renders a WHERE condition matching the rows whose s2cell
+// column falls within any of ranges, and the arguments to bind to it. The
+// column holds leaf cell IDs as signed BIGINTs.
+func CellRangesWhere(ranges []CellRange) (string, []interface{}) {
+	terms := make([]string, len(ranges))
+	var args []interface{}
+	for i, r := range ranges {
+		terms[i] = "(s2cell BETWEEN ? AND ?)"
+		args = append(args, int64(r.Min), int64(r.Max))
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+func init() {
+	RegisterStrategy(&templateStrategy{
+		name:        "s2cell",
+		description: "ranges of S2-style cells covering the circle on the indexed s2cell column, then the law of cosines",
+		requires:    []Requirement{{"column", "s2cell"}, {"index", "s2cell"}, {"column", "lon"}, {"column", "lat"}},
+		distance:    InlineDistance,
+		args:        latLonLat,
+		prefilter: func(p Point, radiusKm float64, _ int) (string, []interface{}) {
+			return CellRangesWhere(CellRanges(DefaultCoverer.Covering(NewCap(p, radiusKm))))
+		},
+	})
+}
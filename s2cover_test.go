@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// rangesContain reports whether any of ranges holds the leaf cell id.
+func rangesContain(ranges []CellRange, id CellID) bool {
+	for _, r := range ranges {
+		if int64(id) >= int64(r.Min) && int64(id) <= int64(r.Max) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCapCovering(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	centers := []Point{{0, 90}, {0, -90}, {45, 0}, {180, 0}, {45, 35.26438968}, {-135, -35.26438968}}
+	for n := 0; n < 60; n++ {
+		centers = append(centers, randomPoint(rnd))
+	}
+	radii := []float64{0.01, 0.2, 2, 20, 500, 5000, 15000}
+	for _, center := range centers {
+		for _, radius := range radii {
+			covering := DefaultCoverer.Covering(NewCap(center, radius))
+			if len(covering) > DefaultCoverer.MaxCells {
+				t.Errorf("the covering of %g km around %v has %d cells", radius, center, len(covering))
+			}
+			for k := 1; k < len(covering); k++ {
+				if covering[k] <= covering[k-1] {
+					t.Fatalf("the covering of %g km around %v is out of order", radius, center)
+				}
+			}
+			ranges := CellRanges(covering)
+			for k := 0; k < 200; k++ {
+				// Half the points are on the rim of the cap.
+				distance := radius * rnd.Float64()
+				if k%2 == 0 {
+					distance = radius * (1 - 1e-9)
+				}
+				p := Destination(center, rnd.Float64()*360, distance)
+				if !rangesContain(ranges, CellIDFromPoint(p)) {
+					t.Fatalf("%v, %g km from %v, is in none of the ranges of %v", p, distance, center, covering)
+				}
+			}
+		}
+	}
+}
+
+func TestRectCovering(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	// The boxes around a point by the antimeridian are two.
+	rect := Rect(BoundingBoxes(Point{179.5, 10}, 100))
+	covering := RegionCoverer{MaxLevel: CellMaxLevel, MaxCells: 12}.Covering(rect)
+	ranges := CellRanges(covering)
+	for k := 0; k < 2000; k++ {
+		b := rect[k%len(rect)]
+		p := Point{b.MinLon + rnd.Float64()*(b.MaxLon-b.MinLon), b.MinLat + rnd.Float64()*(b.MaxLat-b.MinLat)}
+		if !rangesContain(ranges, CellIDFromPoint(p)) {
+			t.Fatalf("%v, in %v, is in none of the ranges of %v", p, b, covering)
+		}
+	}
+}
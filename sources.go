@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Source is a CSV input for load: a plain or gzipped file, or a CSV member
+// of a zip archive.
+type Source struct {
+	Path    string // where the source came from, for messages
+	Name    string // path of the CSV relative to the archive or directory given, or as given for a file
+	Region  string // region derived from Name, used when a row has none
+	Country string // country code derived from Name, used to check coordinates
+	open    func() (io.ReadCloser, error)
+}
+
+// Open returns a reader for the uncompressed CSV.
+func (s *Source) Open() (io.ReadCloser, error) {
+	return s.open()
+}
+
+// placeFromName derives the country code and region of an OpenAddresses
+// source from its path, which ends country/region/source.csv or
+// country/source.csv, after whatever directories hold the country
+// directories. The country is the grandparent directory, or the parent if
+// that has no region, whichever is a country of CountryBoxes, or failing
+// that, a two letter code. Sources with neither have no country or region.
+func placeFromName(name string) (country, region string) {
+	parts := strings.Split(path.Clean(filepath.ToSlash(name)), "/")
+	dirs := parts[:len(parts)-1]
+	grandparent, parent := "", ""
+	if len(dirs) >= 1 {
+		parent = strings.ToLower(dirs[len(dirs)-1])
+	}
+	if len(dirs) >= 2 {
+		grandparent = strings.ToLower(dirs[len(dirs)-2])
+	}
+	known := func(code string) bool {
+		_, ok := CountryBoxes[code]
+		return ok
+	}
+	twoLetters := func(code string) bool {
+		return len(code) == 2 && code[0] >= 'a' && code[0] <= 'z' && code[1] >= 'a' && code[1] <= 'z'
+	}
+	switch {
+	case known(grandparent):
+		return grandparent, strings.ToUpper(parent)
+	case known(parent):
+		return parent, ""
+	case twoLetters(grandparent):
+		return grandparent, strings.ToUpper(parent)
+	case twoLetters(parent):
+		return parent, ""
+	}
+	return "", ""
+}
+
+// matchesAny reports whether name, or its base name, matches any of the glob
+// patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceFilter selects sources by include and exclude globs.
+type sourceFilter struct {
+	include []string
+	exclude []string
+}
+
+func (f sourceFilter) accepts(name string) bool {
+	if len(f.include) > 0 && !matchesAny(name, f.include) {
+		return false
+	}
+	return !matchesAny(name, f.exclude)
+}
+
+// FindSources expands the paths given to load into the CSV sources to read.
+// A path may be a .csv or .csv.gz file, a .zip archive, whose CSV members are
+// read, or a directory, which is searched for all three. A file given
+// directly is named by its path as given, and the files of a directory by
+// their paths within it. The country and region of a file come from the
+// directories nearest it on disk, and those of a zip member from its path in
+// the archive. Names are matched against the include and exclude globs; an
+// empty include matches every source.
+func FindSources(paths, include, exclude []string) ([]Source, error) {
+	filter := sourceFilter{include, exclude}
+	var sources []Source
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			found, err := fileSources(p, filepath.ToSlash(filepath.Clean(p)), filter)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, found...)
+			continue
+		}
+		err = filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(p, file)
+			if err != nil {
+				return err
+			}
+			found, err := fileSources(file, filepath.ToSlash(rel), filter)
+			sources = append(sources, found...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+// fileSources returns the sources in a single file. Files that are neither
+// CSV nor zip archives are ignored.
+func fileSources(file, name string, filter sourceFilter) ([]Source, error) {
+	lower := strings.ToLower(file)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return zipSources(file, filter)
+	case strings.HasSuffix(lower, ".csv.gz"):
+		if !filter.accepts(name) {
+			return nil, nil
+		}
+		country, region := placeFromName(file)
+		return []Source{{
+			Path:    file,
+			Name:    strings.TrimSuffix(name, path.Ext(name)),
+			Region:  region,
+			Country: country,
+			open:    func() (io.ReadCloser, error) { return openGzip(file) },
+		}}, nil
+	case strings.HasSuffix(lower, ".csv"):
+		if !filter.accepts(name) {
+			return nil, nil
+		}
+		country, region := placeFromName(file)
+		return []Source{{
+			Path:    file,
+			Name:    name,
+			Region:  region,
+			Country: country,
+			open:    func() (io.ReadCloser, error) { return os.Open(file) },
+		}}, nil
+	}
+	return nil, nil
+}
+
+// zipSources returns a source for every CSV member of a zip archive.
+func zipSources(file string, filter sourceFilter) ([]Source, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var sources []Source
+	for _, member := range archive.File {
+		name := member.Name
+		if member.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(name), ".csv") || !filter.accepts(name) {
+			continue
+		}
+		country, region := placeFromName(name)
+		sources = append(sources, Source{
+			Path:    file + "!" + name,
+			Name:    name,
+			Region:  region,
+			Country: country,
+			open:    func() (io.ReadCloser, error) { return openZipMember(file, name) },
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources, nil
+}
+
+// multiCloser closes a reader together with the files underneath it.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var first error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func openGzip(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &multiCloser{gz, []io.Closer{gz, f}}, nil
+}
+
+func openZipMember(file, name string) (io.ReadCloser, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range archive.File {
+		if member.Name != name {
+			continue
+		}
+		r, err := member.Open()
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		return &multiCloser{r, []io.Closer{r, archive}}, nil
+	}
+	archive.Close()
+	return nil, fmt.Errorf("%s: no member %s", file, name)
+}
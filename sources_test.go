@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindSourcesNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data/at/wien/city.csv", "data/at/countrywide.csv", "us/ca/berkeley.csv", "kr/seoul/city.csv"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("LON,LAT\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	tests := []struct {
+		path            string
+		name            string
+		region, country string
+	}{
+		// Files given directly, under directories of any depth.
+		{"data/at/wien/city.csv", "data/at/wien/city.csv", "WIEN", "at"},
+		{"./data/at/wien/../wien/city.csv", "data/at/wien/city.csv", "WIEN", "at"},
+		{"data/at/countrywide.csv", "data/at/countrywide.csv", "", "at"},
+		{filepath.Join(dir, "data", "at", "wien", "city.csv"), filepath.ToSlash(filepath.Join(dir, "data", "at", "wien", "city.csv")), "WIEN", "at"},
+		// A region code that is a country code too.
+		{"us/ca/berkeley.csv", "us/ca/berkeley.csv", "CA", "us"},
+		// A country missing from CountryBoxes.
+		{"kr/seoul/city.csv", "kr/seoul/city.csv", "SEOUL", "kr"},
+		// Directories name their files by the path within them, but the
+		// country and region come from the directories above too.
+		{"data", "at/countrywide.csv", "", "at"},
+		{"data/at", "countrywide.csv", "", "at"},
+		{"data/at/wien", "city.csv", "WIEN", "at"},
+	}
+	for _, test := range tests {
+		sources, err := FindSources([]string{test.path}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sources) == 0 {
+			t.Fatalf("no sources found in %s", test.path)
+		}
+		s := sources[0]
+		if s.Name != test.name || s.Region != test.region || s.Country != test.country {
+			t.Errorf("FindSources(%s) found %s in region %q of country %q, want %s in %q of %q",
+				test.path, s.Name, s.Region, s.Country, test.name, test.region, test.country)
+		}
+	}
+}
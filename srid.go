@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GeographicSRID is the SRID of WGS 84 longitude/latitude, the geographic
+// spatial reference system MySQL 8 measures distances on the ellipsoid in.
+const GeographicSRID = 4326
+
+// GeographicVersion is the first MySQL version whose ST_Distance measures
+// between points in a geographic SRS.
+const GeographicVersion = "8.0.14"
+
+// ServerInfo describes the version of the database server.
+type ServerInfo struct {
+	Version string // as reported by VERSION()
+	Major   int
+	Minor   int
+	Patch   int
+	MariaDB bool
+}
+
+// ParseServerVersion parses a version string such as 8.0.33, 5.7.42-log or
+// 10.6.12-MariaDB.
+func ParseServerVersion(version string) ServerInfo {
+	info := ServerInfo{Version: version, MariaDB: strings.Contains(strings.ToLower(version), "mariadb")}
+	number := version
+	if i := strings.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+		number = version[:i]
+	}
+	parts := strings.Split(number, ".")
+	for i, field := range []*int{&info.Major, &info.Minor, &info.Patch} {
+		if i < len(parts) {
+			*field, _ = strconv.Atoi(parts[i])
+		}
+	}
+	return info
+}
+
+// GetServerInfo queries the version of the server db is connected to.
+func GetServerInfo(db *sql.DB) (ServerInfo, error) {
+	var version string
+	if err := db.QueryRow("SELECT VERSION();").Scan(&version); err != nil {
+		return ServerInfo{}, err
+	}
+	return ParseServerVersion(version), nil
+}
+
+// AtLeast reports whether the server is MySQL of at least the given
+// version. MariaDB's version numbers don't follow MySQL's, and it lacks
+// MySQL 8's spatial reference systems, so it never is.
+func (s ServerInfo) AtLeast(version string) bool {
+	if s.MariaDB {
+		return false
+	}
+	want := ParseServerVersion(version)
+	if s.Major != want.Major {
+		return s.Major > want.Major
+	}
+	if s.Minor != want.Minor {
+		return s.Minor > want.Minor
+	}
+	return s.Patch >= want.Patch
+}
+
+// ColumnExists reports whether table in schema has column.
+func ColumnExists(schema, table, column string, db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_NAME = ?;", schema, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// ellipsoidPadding widens the spherical bounding boxes used to prefilter
+// ellipsoidal searches, since distances on the ellipsoid differ from those
+// on the sphere by up to half a percent.
+const ellipsoidPadding = 1.01
+
+// BoxesGeogWhere renders a WHERE condition matching the rows whose geog
+// column falls within any of boxes, and the arguments to bind to it. The
+// boxes are written longitude first, so the axis order is given explicitly;
+// WKT in SRID 4326 is otherwise read latitude first.
+func BoxesGeogWhere(boxes []Box) (string, []interface{}) {
+	terms := make([]string, len(boxes))
+	var args []interface{}
+	for i, b := range boxes {
+		terms[i] = fmt.Sprintf("MBRContains(ST_GeomFromText(?, %d, 'axis-order=long-lat'), geog)", GeographicSRID)
+		args = append(args, b.WKT())
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+func init() {
+	requires := []Requirement{{"server", GeographicVersion}, {"column", "geog"}}
+	RegisterStrategy(&templateStrategy{
+		name:        "ellipsoid",
+		description: "ST_Distance on the WGS 84 ellipsoid over the SRID 4326 geog column",
+		requires:    requires,
+		ellipsoidal: true,
+		distance:    EllipsoidDistance,
+		args:        lonLat,
+	})
+	RegisterStrategy(&templateStrategy{
+		name:        "ellipsoid-mbr",
+		description: "MBRContains on the geog SPATIAL KEY, then ST_Distance on the WGS 84 ellipsoid",
+		requires:    append(requires, Requirement{"index", "geog"}),
+		ellipsoidal: true,
+		distance:    EllipsoidDistance,
+		args:        lonLat,
+		prefilter: func(p Point, radiusKm float64, _ int) (string, []interface{}) {
+			return BoxesGeogWhere(BoundingBoxes(p, radiusKm*ellipsoidPadding))
+		},
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Summary holds the order statistics reported for a set of timings.
+type Summary struct {
+	Count  int
+	Min    time.Duration
+	Median time.Duration
+	P95    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// Summarize computes the order statistics of samples. The samples are not
+// modified.
+func Summarize(samples []time.Duration) Summary {
+	if len(samples) == 0 {
+		return Summary{}
+	}
+	sorted := durationsToFloats(samples)
+	sort.Float64s(sorted)
+
+	return Summary{
+		Count:  len(sorted),
+		Min:    time.Duration(sorted[0]),
+		Median: time.Duration(percentile(sorted, 50)),
+		P95:    time.Duration(percentile(sorted, 95)),
+		P99:    time.Duration(percentile(sorted, 99)),
+		Max:    time.Duration(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of an ascending slice.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// durationsToFloats converts durations to float64 nanoseconds.
+func durationsToFloats(samples []time.Duration) []float64 {
+	f := make([]float64, len(samples))
+	for i, s := range samples {
+		f[i] = float64(s)
+	}
+	return f
+}
+
+// median returns the median of samples, averaging the two middle values of
+// an even-sized set. The samples are not modified.
+func median(samples []float64) float64 {
+	if len(samples) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// MannWhitneyU performs a two-sided Mann-Whitney U test of whether samples a
+// and b come from the same distribution. It returns the U statistic for a and
+// the p-value, using the normal approximation with a correction for ties.
+func MannWhitneyU(a, b []float64) (u, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	type ranked struct {
+		value float64
+		fromA bool
+	}
+	all := make([]ranked, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, ranked{v, true})
+	}
+	for _, v := range b {
+		all = append(all, ranked{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Assign average ranks to ties, and accumulate the tie correction term.
+	var rankSumA, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	u = rankSumA - n1*(n1+1)/2
+	n := n1 + n2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	// Continuity correction towards the mean.
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return u, math.Erfc(z / math.Sqrt2)
+}
+
+// BootstrapMedianDelta estimates a confidence interval for the relative
+// change in median from a to b, (median(b) - median(a)) / median(a), by
+// resampling both sets with replacement. The interval covers 1-alpha.
+func BootstrapMedianDelta(a, b []float64, resamples int, alpha float64, rnd *rand.Rand) (lo, hi float64) {
+	if len(a) == 0 || len(b) == 0 || resamples <= 0 {
+		return math.NaN(), math.NaN()
+	}
+	deltas := make([]float64, resamples)
+	ra := make([]float64, len(a))
+	rb := make([]float64, len(b))
+	for i := range deltas {
+		for j := range ra {
+			ra[j] = a[rnd.Intn(len(a))]
+		}
+		for j := range rb {
+			rb[j] = b[rnd.Intn(len(b))]
+		}
+		ma := median(ra)
+		deltas[i] = (median(rb) - ma) / ma
+	}
+	sort.Float64s(deltas)
+	lo = deltas[int(math.Floor(alpha/2*float64(resamples-1)))]
+	hi = deltas[int(math.Ceil((1-alpha/2)*float64(resamples-1)))]
+	return lo, hi
+}
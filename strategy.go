@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Match is a row found by a QueryStrategy, and its distance from the point
+// searched for.
+type Match struct {
+	ID       string
+	Distance sql.NullFloat64
+}
+
+// Search describes a radius search. Every strategy honors it the same way.
+type Search struct {
+	Radius float64 // Only rows within Radius are returned. 0 returns every row
+	Unit   string  // Unit of Radius and of the distances returned, one of LengthUnits. Defaults to km
+	Earth  string  // Earth model the distances are measured on, one of EarthModels. Defaults to mean
+	Limit  int     // Maximum number of rows to return. 0 returns every row
+	Order  string  // distance for nearest first, id, or none
+	SRID   int     // SRID of the table's geom column: 0, but for the variant tables of SRID 4326
+}
+
+// Model returns the unit and Earth model of the search.
+func (s Search) Model() (Length, EarthModel, error) {
+	unit, err := ParseUnit(s.Unit)
+	if err != nil {
+		return 0, EarthModel{}, err
+	}
+	earth, err := LookupEarthModel(s.Earth)
+	return unit, earth, err
+}
+
+// Requirement is a schema object that a QueryStrategy depends on.
+type Requirement struct {
+	Kind string // column, index, function, parameter (function.parameter), or server for a minimum server version
+	Name string
+}
+
+func (r Requirement) String() string {
+	return r.Kind + " " + r.Name
+}
+
+// QueryStrategy is a way of finding the rows of the address table near a
+// point. Strategies are registered with RegisterStrategy, and every command
+// that runs queries picks them up from the registry.
+type QueryStrategy interface {
+	// Name is the short name used to choose the strategy on the command line.
+	Name() string
+	// Description is a one line summary of how the strategy works.
+	Description() string
+	// SQL renders the query against table for the rows matching search
+	// around p, and the arguments to bind to its placeholders.
+	SQL(table string, p Point, search Search) (string, []interface{})
+	// Scan reads the current row of the query's result.
+	Scan(rows *sql.Rows) (Match, error)
+	// Requires lists the schema objects the query depends on.
+	Requires() []Requirement
+}
+
+var strategies = make(map[string]QueryStrategy)
+var strategyOrder []string
+
+// RegisterStrategy adds a strategy to the registry. It panics if a strategy
+// with the same name is already registered.
+func RegisterStrategy(s QueryStrategy) {
+	if _, ok := strategies[s.Name()]; ok {
+		panic(fmt.Sprintf("RegisterStrategy - duplicate query strategy: %s", s.Name()))
+	}
+	strategies[s.Name()] = s
+	strategyOrder = append(strategyOrder, s.Name())
+}
+
+// LookupStrategy returns the registered strategy called name.
+func LookupStrategy(name string) (QueryStrategy, error) {
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown query type: %s", name)
+	}
+	return s, nil
+}
+
+// Strategies returns the registered strategies in registration order.
+func Strategies() []QueryStrategy {
+	list := make([]QueryStrategy, len(strategyOrder))
+	for i, name := range strategyOrder {
+		list[i] = strategies[name]
+	}
+	return list
+}
+
+// StrategyNames returns the names of the registered strategies in
+// registration order.
+func StrategyNames() []string {
+	names := make([]string, len(strategyOrder))
+	copy(names, strategyOrder)
+	return names
+}
+
+// StrategyHelp returns the strategy names and descriptions for use in a
+// flag's help text.
+func StrategyHelp() string {
+	var help []string
+	for _, s := range Strategies() {
+		help = append(help, fmt.Sprintf("%s: %s", s.Name(), s.Description()))
+	}
+	return strings.Join(help, "; ")
+}
+
+// CheckRequirements returns an error naming the schema objects that s
+// requires but are missing from table, or the server version it needs.
+func CheckRequirements(s QueryStrategy, schema, table string, db *sql.DB) error {
+	var missing []string
+	for _, r := range s.Requires() {
+		if r.Kind == "server" {
+			server, err := GetServerInfo(db)
+			if err != nil {
+				return err
+			}
+			if !server.AtLeast(r.Name) {
+				return fmt.Errorf("query type %s needs MySQL %s; the server is %s", s.Name(), r.Name, server.Version)
+			}
+			continue
+		}
+		var query string
+		args := []interface{}{schema, table, r.Name}
+		switch r.Kind {
+		case "column":
+			query = "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_NAME = ?;"
+		case "index":
+			query = "SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND INDEX_NAME = ?;"
+		case "function":
+			query = "SELECT COUNT(*) FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = 'FUNCTION' AND ROUTINE_NAME = ?;"
+			args = []interface{}{schema, r.Name}
+		case "parameter":
+			query = "SELECT COUNT(*) FROM information_schema.PARAMETERS WHERE SPECIFIC_SCHEMA = ? AND SPECIFIC_NAME = ? AND PARAMETER_NAME = ?;"
+			function := strings.SplitN(r.Name, ".", 2)
+			if len(function) != 2 {
+				return fmt.Errorf("%s: parameter requirement %s must be function.parameter", s.Name(), r.Name)
+			}
+			args = []interface{}{schema, function[0], function[1]}
+		default:
+			return fmt.Errorf("%s: unknown requirement kind %s", s.Name(), r.Kind)
+		}
+		var count int
+		if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			missing = append(missing, r.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("query type %s needs %s in %s.%s", s.Name(), strings.Join(missing, ", "), schema, table)
+	}
+	return nil
+}
+
+// templateStrategy is a QueryStrategy built from one of the distance
+// expressions in db.go, optionally with a prefilter that restricts the rows
+// before distances are computed. Its queries select id and distance.
+//
+// The spherical expressions return the central angle in radians, which the
+// query scales by the radius of the search's Earth model, so that every
+// spherical strategy measures on the same sphere. Ellipsoidal expressions
+// return meters on WGS 84, whatever the model.
+type templateStrategy struct {
+	name         string
+	description  string
+	requires     []Requirement
+	ellipsoidal  bool                                                              // whether the distance expression returns meters on WGS 84
+	distance     string                                                            // the distance expression
+	sridDistance string                                                            // the distance expression for a geom column with an SRID, which it binds last
+	args         func(p Point) []interface{}                                       // binds the distance expression
+	prefilter    func(p Point, radiusKm float64, srid int) (string, []interface{}) // renders a WHERE condition, may be nil
+}
+
+func (s *templateStrategy) Name() string            { return s.name }
+func (s *templateStrategy) Description() string     { return s.description }
+func (s *templateStrategy) Requires() []Requirement { return s.requires }
+
+// Indexed reports whether the strategy has a prefilter, which restricts its
+// radius searches by an index.
+func (s *templateStrategy) Indexed() bool { return s.prefilter != nil }
+
+// scale returns the factor that converts the distance expression into unit
+// under the Earth model earth.
+func (s *templateStrategy) scale(unit Length, earth EarthModel) float64 {
+	if s.ellipsoidal {
+		return Meter.In(unit)
+	}
+	return earth.Radius.In(unit)
+}
+
+// SQL renders the query. A search with an unknown unit or Earth model falls
+// back to kilometers on the mean sphere; the command line only offers known
+// ones.
+func (s *templateStrategy) SQL(table string, p Point, search Search) (string, []interface{}) {
+	unit, earth, err := search.Model()
+	if err != nil {
+		unit, earth = Kilometer, MeanEarth
+	}
+	distance := fmt.Sprintf("(? * %s)", s.distance)
+	args := append([]interface{}{s.scale(unit, earth)}, s.args(p)...)
+	if search.SRID != 0 && s.sridDistance != "" {
+		// MySQL only compares geometries of the same SRID.
+		distance = fmt.Sprintf("(? * %s)", s.sridDistance)
+		args = append(args, search.SRID)
+	}
+
+	var where, having, order, limit string
+	if search.Radius > 0 {
+		if s.prefilter != nil {
+			// The boxes are computed on the sphere of radius Rk, so a
+			// spherical radius is first carried over to that sphere.
+			radius := Length(search.Radius) * unit
+			if !s.ellipsoidal {
+				radius = MeanEarth.Sphere(radius.In(earth.Radius))
+			}
+			condition, whereArgs := s.prefilter(p, radius.Kilometers(), search.SRID)
+			where = " WHERE " + condition
+			args = append(args, whereArgs...)
+		}
+		having = " HAVING distance <= ?"
+		args = append(args, search.Radius)
+	}
+	switch search.Order {
+	case "distance":
+		order = " ORDER BY distance"
+	case "id":
+		order = " ORDER BY id"
+	}
+	if search.Limit > 0 {
+		limit = " LIMIT ?"
+		args = append(args, search.Limit)
+	}
+	return fmt.Sprintf(RadiusQuery, QuoteTable(table), distance, where, having, order, limit), args
+}
+
+func (s *templateStrategy) Scan(rows *sql.Rows) (Match, error) {
+	var m Match
+	err := rows.Scan(&m.ID, &m.Distance)
+	return m, err
+}
+
+// latLonLat binds a distance expression with the lat, lon and lat of p.
+func latLonLat(p Point) []interface{} {
+	return []interface{}{p.Lat, p.Lon, p.Lat}
+}
+
+// lonLat binds a distance expression with the lon and lat of p.
+func lonLat(p Point) []interface{} {
+	return []interface{}{p.Lon, p.Lat}
+}
+
+// inRadians converts a binding function to bind p in radians.
+func inRadians(bind func(p Point) []interface{}) func(p Point) []interface{} {
+	return func(p Point) []interface{} {
+		return bind(Point{rad(p.Lon), rad(p.Lat)})
+	}
+}
+
+func init() {
+	RegisterStrategy(&templateStrategy{
+		name:        "inline",
+		description: "law of cosines in SQL over the degree columns",
+		requires:    []Requirement{{"column", "lon"}, {"column", "lat"}},
+		distance:    InlineDistance,
+		args:        latLonLat,
+	})
+	RegisterStrategy(&templateStrategy{
+		name:        "radians",
+		description: "law of cosines in SQL over the precomputed radian columns",
+		requires:    []Requirement{{"column", "rlon_d"}, {"column", "rlat_d"}},
+		distance:    InlineRadiansDistance,
+		args:        inRadians(latLonLat),
+	})
+	RegisterStrategy(&templateStrategy{
+		name:        "stored",
+		description: "the distance_loc stored function",
+		requires:    []Requirement{{"parameter", "distance_loc.radius"}, {"column", "rlon_d"}, {"column", "rlat_d"}},
+		distance:    StoredFuncDistance,
+		args:        inRadians(lonLat),
+	})
+	RegisterStrategy(&templateStrategy{
+		name:         "spatial",
+		description:  "st_distance_sphere over the geom column",
+		requires:     []Requirement{{"column", "geom"}},
+		distance:     SpatialFuncDistance,
+		sridDistance: SpatialFuncDistanceSRID,
+		args:         lonLat,
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Length is a distance, stored in meters. Multiply a number by one of the
+// unit constants to make a Length, and use In to read it in another unit.
+type Length float64
+
+// The units of length distances can be given and returned in.
+const (
+	Meter        Length = 1
+	Kilometer    Length = 1000
+	Mile         Length = 1609.344 // statute mile
+	NauticalMile Length = 1852
+	Foot         Length = 0.3048
+)
+
+// LengthUnits maps the names of the units accepted by --unit to the units.
+var LengthUnits = map[string]Length{
+	"km":  Kilometer,
+	"m":   Meter,
+	"mi":  Mile,
+	"nmi": NauticalMile,
+	"ft":  Foot,
+}
+
+// LengthUnitNames lists the names in LengthUnits, kilometers first.
+var LengthUnitNames = []string{"km", "m", "mi", "nmi", "ft"}
+
+// ParseUnit returns the unit called name. An empty name is kilometers.
+func ParseUnit(name string) (Length, error) {
+	if name == "" {
+		return Kilometer, nil
+	}
+	unit, ok := LengthUnits[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s, expected one of %s", name, strings.Join(LengthUnitNames, ", "))
+	}
+	return unit, nil
+}
+
+// In returns the length as a number of unit.
+func (l Length) In(unit Length) float64 {
+	return float64(l / unit)
+}
+
+// Meters returns the length in meters.
+func (l Length) Meters() float64 { return float64(l) }
+
+// Kilometers returns the length in kilometers.
+func (l Length) Kilometers() float64 { return l.In(Kilometer) }
+
+// Miles returns the length in statute miles.
+func (l Length) Miles() float64 { return l.In(Mile) }
+
+// EarthModel is the figure of the Earth that distances are measured on. The
+// spherical formulas, in Go and in SQL, all use its Radius, so strategies
+// measured under the same model return the same distances in the same unit.
+type EarthModel struct {
+	Name        string
+	Description string
+	Radius      Length     // radius of the sphere
+	Ellipsoid   *Ellipsoid // the ellipsoid the radius approximates, if any
+}
+
+// The Earth models that --earth chooses between. The IUGG mean radius
+// R1 = (2a + b) / 3 of the WGS 84 ellipsoid minimizes the error of the
+// spherical formulas on average; the authalic sphere has the ellipsoid's
+// area, and the equatorial one its semi-major axis.
+var (
+	MeanEarth       = EarthModel{"mean", "sphere of the IUGG mean radius, 6371.0088 km", 6371.0088 * Kilometer, nil}
+	EquatorialEarth = EarthModel{"equatorial", "sphere of the WGS 84 equatorial radius, 6378.137 km", 6378.137 * Kilometer, nil}
+	AuthalicEarth   = EarthModel{"authalic", "sphere of the same area as WGS 84, 6371.0072 km", 6371.0072 * Kilometer, nil}
+	WGS84Earth      = EarthModel{"wgs84", "the WGS 84 ellipsoid, or its mean radius where a sphere is needed", 6371.0088 * Kilometer, &WGS84}
+)
+
+// EarthModels lists the Earth models, the default first.
+var EarthModels = []EarthModel{MeanEarth, EquatorialEarth, AuthalicEarth, WGS84Earth}
+
+// EarthModelNames returns the names of the Earth models.
+func EarthModelNames() []string {
+	names := make([]string, len(EarthModels))
+	for i, m := range EarthModels {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// EarthModelHelp returns the Earth model names and descriptions for use in a
+// flag's help text.
+func EarthModelHelp() string {
+	help := make([]string, len(EarthModels))
+	for i, m := range EarthModels {
+		help[i] = fmt.Sprintf("%s: %s", m.Name, m.Description)
+	}
+	return strings.Join(help, "; ")
+}
+
+// LookupEarthModel returns the Earth model called name. An empty name is
+// the mean sphere.
+func LookupEarthModel(name string) (EarthModel, error) {
+	if name == "" {
+		return MeanEarth, nil
+	}
+	for _, m := range EarthModels {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return EarthModel{}, fmt.Errorf("unknown Earth model %s, expected one of %s", name, strings.Join(EarthModelNames(), ", "))
+}
+
+// Sphere returns the length of an arc of the model's sphere subtending
+// angle radians.
+func (m EarthModel) Sphere(angle float64) Length {
+	return Length(angle) * m.Radius
+}
+
+// Distance returns the distance from p to q: along the geodesic if the
+// model is an ellipsoid, and by the haversine formula otherwise.
+func (m EarthModel) Distance(p, q Point) Length {
+	if m.Ellipsoid != nil {
+		return Length(m.Ellipsoid.Inverse(p, q).Distance) * Kilometer
+	}
+	return m.Sphere(CentralAngle(p, q))
+}
+
+// CentralAngle returns the angle in radians between p and q at the center
+// of the sphere, by the haversine formula.
+func CentralAngle(p, q Point) float64 {
+	h := hav(rad(q.Lat-p.Lat)) + math.Cos(rad(p.Lat))*math.Cos(rad(q.Lat))*hav(rad(q.Lon-p.Lon))
+	return 2 * math.Asin(math.Sqrt(math.Min(1, h)))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RowStep is a stage of the pipeline every loaded row goes through. A step
+// may change the address, or reject it by returning a *RowError.
+type RowStep func(a *Address) error
+
+// Pipeline is the ordered list of steps applied to each loaded row.
+type Pipeline []RowStep
+
+// Apply runs the steps in order, stopping at the first that rejects a.
+func (p Pipeline) Apply(a *Address) error {
+	for _, step := range p {
+		if err := step(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RowChecks are the names of the checks load can run on each row.
+var RowChecks = []string{"range", "null-island", "swapped", "length"}
+
+// RowNormalizers are the names of the normalizations load can apply to each
+// row.
+var RowNormalizers = []string{"space", "case"}
+
+// NewPipeline builds the pipeline for a source from the names of the checks
+// and normalizations to apply; "none" in either list is ignored. country is
+// the source's country code, used by the swapped check, and postal, if set,
+// is prefixed to every postcode. Coordinates are checked first, then the text
+// is normalized, and the lengths are checked last.
+func NewPipeline(checks, normalizers []string, country, postal string) Pipeline {
+	enabled := make(map[string]bool)
+	for _, name := range checks {
+		enabled[name] = true
+	}
+	for _, name := range normalizers {
+		enabled[name] = true
+	}
+
+	var p Pipeline
+	if enabled["range"] {
+		p = append(p, checkRange)
+	}
+	if enabled["null-island"] {
+		p = append(p, checkNullIsland)
+	}
+	if box, ok := CountryBoxes[strings.ToLower(country)]; ok && enabled["swapped"] {
+		p = append(p, checkSwapped(box))
+	}
+	if enabled["space"] {
+		p = append(p, normalizeSpace)
+	}
+	if enabled["case"] {
+		p = append(p, normalizeCase)
+	}
+	if postal != "" {
+		p = append(p, prefixPostcode(postal))
+	}
+	if enabled["length"] {
+		p = append(p, checkLength)
+	}
+	return p
+}
+
+// checkRange rejects coordinates that are not on the globe.
+func checkRange(a *Address) error {
+	if math.IsNaN(a.Lon) || math.IsInf(a.Lon, 0) || a.Lon < -180 || a.Lon > 180 {
+		return rowErrorf("range", "longitude %v out of range", a.Lon)
+	}
+	if math.IsNaN(a.Lat) || math.IsInf(a.Lat, 0) || a.Lat < -90 || a.Lat > 90 {
+		return rowErrorf("range", "latitude %v out of range", a.Lat)
+	}
+	return nil
+}
+
+// NullIslandTolerance is how close to 0,0, in degrees, a point has to be to
+// be taken for a missing location.
+const NullIslandTolerance = 1e-6
+
+// checkNullIsland rejects points at 0,0, which geocoders produce for
+// addresses they could not locate.
+func checkNullIsland(a *Address) error {
+	if math.Abs(a.Lon) < NullIslandTolerance && math.Abs(a.Lat) < NullIslandTolerance {
+		return rowErrorf("null-island", "location is 0,0")
+	}
+	return nil
+}
+
+// checkSwapped returns a check that rejects points outside box that would be
+// inside it with lon and lat swapped. Points that are outside box either way
+// are left alone, since the box only covers a country's main territory.
+func checkSwapped(box Box) RowStep {
+	return func(a *Address) error {
+		if !box.Contains(Point{a.Lon, a.Lat}) && box.Contains(Point{a.Lat, a.Lon}) {
+			return rowErrorf("swapped", "lon %v and lat %v look swapped", a.Lon, a.Lat)
+		}
+		return nil
+	}
+}
+
+// CountryBoxes are the rough bounding boxes of the main territory of the
+// countries OpenAddresses covers best, by the country code sources are filed
+// under. They are used to detect swapped coordinates.
+var CountryBoxes = map[string]Box{
+	"at": {9.53, 46.37, 17.16, 49.02},
+	"au": {112.92, -43.64, 153.64, -10.06},
+	"be": {2.54, 49.50, 6.41, 51.51},
+	"br": {-73.99, -33.75, -34.79, 5.27},
+	"ca": {-141.00, 41.68, -52.62, 83.11},
+	"ch": {5.96, 45.82, 10.49, 47.81},
+	"cz": {12.09, 48.55, 18.86, 51.06},
+	"de": {5.87, 47.27, 15.04, 55.06},
+	"dk": {8.07, 54.56, 15.20, 57.75},
+	"es": {-18.17, 27.64, 4.33, 43.79},
+	"fi": {20.55, 59.81, 31.59, 70.09},
+	"fr": {-5.14, 41.33, 9.56, 51.09},
+	"gb": {-8.65, 49.86, 1.77, 60.86},
+	"it": {6.63, 35.49, 18.52, 47.09},
+	"jp": {122.93, 24.04, 145.82, 45.55},
+	"mx": {-118.37, 14.53, -86.71, 32.72},
+	"nl": {3.36, 50.75, 7.23, 53.56},
+	"no": {4.65, 57.98, 31.08, 71.19},
+	"nz": {166.43, -47.29, 178.55, -34.39},
+	"pl": {14.12, 49.00, 24.15, 54.84},
+	"pt": {-31.27, 32.63, -6.19, 42.15},
+	"se": {11.11, 55.34, 24.17, 69.06},
+	"us": {-179.15, 18.91, -66.95, 71.39},
+	"za": {16.45, -34.84, 32.89, -22.13},
+}
+
+// normalizeSpace trims the text fields and collapses runs of white space in
+// them to a single space.
+func normalizeSpace(a *Address) error {
+	for _, field := range a.textFields() {
+		*field = strings.Join(strings.Fields(*field), " ")
+	}
+	return nil
+}
+
+// normalizeCase title cases street and city names that are all upper or all
+// lower case, leaving mixed case names as they are, and upper cases the
+// postcode.
+func normalizeCase(a *Address) error {
+	a.Street = titleCase(a.Street)
+	a.City = titleCase(a.City)
+	a.Postcode = strings.ToUpper(a.Postcode)
+	return nil
+}
+
+// titleCase upper cases the first letter of each word of s and lower cases
+// the rest, if s is all upper or all lower case.
+func titleCase(s string) string {
+	if s != strings.ToUpper(s) && s != strings.ToLower(s) {
+		return s
+	}
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		if start {
+			b.WriteRune(unicode.ToUpper(r))
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+		start = unicode.IsSpace(r) || r == '-'
+	}
+	return b.String()
+}
+
+// prefixPostcode returns a step that prefixes every postcode with prefix and
+// a dash, or sets it to prefix if the row has none.
+func prefixPostcode(prefix string) RowStep {
+	return func(a *Address) error {
+		if a.Postcode == "" {
+			a.Postcode = prefix
+		} else {
+			a.Postcode = fmt.Sprintf("%s-%s", prefix, a.Postcode)
+		}
+		return nil
+	}
+}
+
+// addressLengths are the lengths of the text columns of the address table,
+// in characters.
+var addressLengths = map[string]int{
+	"number":   32,
+	"street":   64,
+	"unit":     8,
+	"city":     64,
+	"district": 64,
+	"region":   64,
+	"postcode": 16,
+}
+
+// checkLength rejects rows with text too long for the address table's
+// columns, which MySQL would otherwise truncate or refuse.
+func checkLength(a *Address) error {
+	fields := a.textFields()
+	for _, f := range AddressFields {
+		field, ok := fields[f.Name]
+		if !ok {
+			continue
+		}
+		if n := utf8.RuneCountInString(*field); n > addressLengths[f.Name] {
+			return rowErrorf("length", "%s is %d characters, the limit is %d", f.Name, n, addressLengths[f.Name])
+		}
+	}
+	return nil
+}
+
+// textFields returns pointers to the text fields of the address by field
+// name.
+func (a *Address) textFields() map[string]*string {
+	return map[string]*string{
+		"number":   &a.Number,
+		"street":   &a.Street,
+		"unit":     &a.Unit,
+		"city":     &a.City,
+		"district": &a.District,
+		"region":   &a.Region,
+		"postcode": &a.Postcode,
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Variant is a copy of the address table that differs in storage engine,
+// coordinate column type, spatial index or SRID, for comparing how each
+// affects the query strategies.
+type Variant struct {
+	Engine  string // InnoDB or MyISAM
+	Type    string // type of the lon, lat and radian columns: decimal, double or float
+	Spatial bool   // whether geom has a SPATIAL KEY
+	SRID    int    // SRID of geom: 0 or 4326
+}
+
+// VariantEngines, VariantTypes, VariantIndexes and VariantSRIDs are the
+// dimensions of the variant matrix.
+var (
+	VariantEngines = []string{"InnoDB", "MyISAM"}
+	VariantTypes   = []string{"decimal", "double", "float"}
+	VariantIndexes = []string{"spatial", "none"}
+	VariantSRIDs   = []string{"0", "4326"}
+)
+
+// variantColumnTypes maps a variant type to its column definition.
+var variantColumnTypes = map[string]string{
+	"decimal": "decimal(10,7)",
+	"double":  "double",
+	"float":   "float",
+}
+
+// Table returns the name of the variant's copy of base.
+func (v Variant) Table(base string) string {
+	index := "nosp"
+	if v.Spatial {
+		index = "sp"
+	}
+	return fmt.Sprintf("%s_%s_%s_%s_%d", base, strings.ToLower(v.Engine), v.Type, index, v.SRID)
+}
+
+// Variants returns every combination of the given dimension values.
+func Variants(engines, types, indexes, srids []string) []Variant {
+	var variants []Variant
+	for _, engine := range engines {
+		for _, t := range types {
+			for _, index := range indexes {
+				for _, srid := range srids {
+					n, _ := strconv.Atoi(srid)
+					variants = append(variants, Variant{engine, t, index == "spatial", n})
+				}
+			}
+		}
+	}
+	return variants
+}
+
+// CreateVariantQuery creates a variant table. It is formatted with the
+// table name, the coordinate column type, the geom SRID attribute, the
+// SPATIAL KEY clause and the engine.
+var CreateVariantQuery = `CREATE TABLE %[1]s (
+  id INT NOT NULL AUTO_INCREMENT,
+  lon %[2]s NOT NULL,
+  lat %[2]s NOT NULL,
+  geom point NOT NULL%[3]s,
+  rlon_d %[2]s NOT NULL COMMENT 'lon as radians',
+  rlat_d %[2]s NOT NULL COMMENT 'lat as radians',
+  rlon_dd double(10, 7) NOT NULL COMMENT 'lon as double radians',
+  rlat_dd double(10, 7) NOT NULL COMMENT 'lat as double radians',
+  number varchar(32) DEFAULT NULL,
+  street varchar(64) DEFAULT NULL,
+  unit varchar(8) DEFAULT NULL,
+  city varchar(64) DEFAULT NULL,
+  district varchar(64) DEFAULT NULL,
+  region varchar(64) DEFAULT NULL,
+  postcode varchar(16) DEFAULT NULL,
+  PRIMARY KEY (id),
+  KEY lon (lon),
+  KEY lat (lat)%[4]s
+) ENGINE=%[5]s DEFAULT CHARSET='utf8mb4';`
+
+// CopyVariantQuery copies the address table into a variant. It is formatted
+// with the variant table, the address table and the geom expression.
+var CopyVariantQuery = `INSERT INTO %[1]s (id, lon, lat, geom, rlon_d, rlat_d, rlon_dd, rlat_dd, number, street, unit, city, district, region, postcode)
+SELECT id, lon, lat, %[3]s, rlon_d, rlat_d, rlon_dd, rlat_dd, number, street, unit, city, district, region, postcode FROM %[2]s;`
+
+// SQL returns the statements that create the variant's copy of base.
+func (v Variant) SQL(base string) []string {
+	srid, geom := "", "geom"
+	if v.SRID != 0 {
+		// ST_SRID relabels the points without moving them, which needs
+		// MySQL 8.0.
+		srid = fmt.Sprintf(" SRID %d", v.SRID)
+		geom = fmt.Sprintf("ST_SRID(geom, %d)", v.SRID)
+	}
+	index := ""
+	if v.Spatial {
+		index = ",\n  SPATIAL KEY geom (geom)"
+	}
+	table := v.Table(base)
+	return []string{
+		fmt.Sprintf(CreateVariantQuery, QuoteTable(table), variantColumnTypes[v.Type], srid, index, v.Engine),
+		fmt.Sprintf(CopyVariantQuery, QuoteTable(table), QuoteTable(base), geom),
+	}
+}
+
+// TableExists reports whether table exists in schema.
+func TableExists(schema, table string, db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?;", schema, table).Scan(&count)
+	return count > 0, err
+}
+
+// selectedVariants returns the variants chosen by the variant flags.
+func (gc *GeoCommand) selectedVariants() []Variant {
+	return Variants(gc.VariantEngines, gc.VariantTypes, gc.VariantIndexes, gc.VariantSRIDs)
+}
+
+// CreateVariants copies the address table into each selected variant table
+// that does not exist yet
+func (gc *GeoCommand) CreateVariants(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+
+	for _, v := range gc.selectedVariants() {
+		table := v.Table(gc.Table)
+		if err := ValidateTable(table); err != nil {
+			return err
+		}
+		exists, err := TableExists(gc.Schema, table, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			fmt.Printf("%s exists, skipping\n", Yellow("%s", table))
+			continue
+		}
+		start := time.Now()
+		for _, statement := range v.SQL(gc.Table) {
+			if _, err := db.Exec(statement); err != nil {
+				return fmt.Errorf("%s: %v", table, err)
+			}
+		}
+		fmt.Printf("Created %s in %s\n", Green("%s", table), time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// DropVariants drops each selected variant table
+func (gc *GeoCommand) DropVariants(ctx *kingpin.ParseContext) error {
+	db := gc.Connect()
+	defer db.Close()
+
+	for _, v := range gc.selectedVariants() {
+		table := v.Table(gc.Table)
+		if err := ValidateTable(table); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s;", QuoteTable(table))); err != nil {
+			return err
+		}
+		fmt.Printf("Dropped %s\n", Yellow("%s", table))
+	}
+	return nil
+}
+
+// MatrixTable is a table a matrix benchmark runs against, and the SRID of
+// its geom column.
+type MatrixTable struct {
+	Name string
+	SRID int
+}
+
+// MatrixTables returns the address table and those of its variants, out of
+// the selected ones, that exist.
+func (gc *GeoCommand) MatrixTables(db *sql.DB) ([]MatrixTable, error) {
+	tables := []MatrixTable{{gc.Table, 0}}
+	for _, v := range gc.selectedVariants() {
+		table := v.Table(gc.Table)
+		if err := ValidateTable(table); err != nil {
+			return nil, err
+		}
+		exists, err := TableExists(gc.Schema, table, db)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			tables = append(tables, MatrixTable{table, v.SRID})
+		}
+	}
+	return tables, nil
+}
+
+// unindexed is a strategy without its index requirements. A matrix runs
+// strategies so on the variants without a spatial index too: the prefilter
+// still works without its index, and how much slower it is is what the
+// variants compare.
+type unindexed struct {
+	QueryStrategy
+}
+
+func (u unindexed) Requires() []Requirement {
+	var requires []Requirement
+	for _, r := range u.QueryStrategy.Requires() {
+		if r.Kind != "index" {
+			requires = append(requires, r)
+		}
+	}
+	return requires
+}
+
+// PrintMatrix prints the median total time of every query type on every
+// table measured, as one table with a column per variant. Query types that
+// failed on a table are marked FAILED, and their errors listed below.
+func PrintMatrix(results *Results) error {
+	var queryTypes, tables []string
+	seenType := make(map[string]bool)
+	seenTable := make(map[string]bool)
+	totals := make(map[string][]time.Duration)
+	for _, m := range results.Measurements {
+		if !seenType[m.QueryType] {
+			seenType[m.QueryType] = true
+			queryTypes = append(queryTypes, m.QueryType)
+		}
+		if !seenTable[m.Variant] {
+			seenTable[m.Variant] = true
+			tables = append(tables, m.Variant)
+		}
+		totals[m.Key()] = append(totals[m.Key()], m.QueryTime+m.FetchTime)
+	}
+	failed := make(map[string]bool)
+	for _, f := range results.Failures {
+		m := Measurement{QueryType: f.QueryType, Variant: f.Variant}
+		failed[m.Key()] = true
+		if !seenType[f.QueryType] {
+			seenType[f.QueryType] = true
+			queryTypes = append(queryTypes, f.QueryType)
+		}
+		if !seenTable[f.Variant] {
+			seenTable[f.Variant] = true
+			tables = append(tables, f.Variant)
+		}
+	}
+
+	fmt.Println("Median query + fetch time")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "table\t"+strings.Join(queryTypes, "\t")+"\t")
+	for _, table := range tables {
+		fmt.Fprint(w, table, "\t")
+		for _, queryType := range queryTypes {
+			m := Measurement{QueryType: queryType, Variant: table}
+			if failed[m.Key()] {
+				fmt.Fprint(w, Red("FAILED"), "\t")
+			} else if samples, ok := totals[m.Key()]; ok {
+				fmt.Fprint(w, Summarize(samples).Median, "\t")
+			} else {
+				fmt.Fprint(w, "-\t")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, f := range results.Failures {
+		fmt.Printf("%s on %s: %s\n", Red("%s", f.QueryType), f.Variant, f.Error)
+	}
+	return nil
+}